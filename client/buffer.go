@@ -0,0 +1,291 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBufferLimit   = 1000
+	DefaultProbeInterval = 2 * time.Second
+)
+
+var (
+	ErrHeld       = errors.New("Destination unavailable, delivery held")
+	ErrBufferFull = errors.New("Buffer full, delivery dropped")
+)
+
+type (
+	// Buffer holds deliveries in order while their destination is down.
+	Buffer interface {
+		Push(d Delivery) error
+		Peek() (Delivery, bool)
+		Pop() error
+		Len() int
+	}
+
+	// Prober is implemented by destinations that can tell whether they are
+	// up without delivering anything.
+	Prober interface {
+		Probe(ctx context.Context) error
+	}
+
+	// MemBuffer keeps up to Limit deliveries in memory.
+	MemBuffer struct {
+		sync.Mutex
+		Limit      int
+		deliveries []Delivery
+	}
+
+	// FileBuffer keeps deliveries in a JSON file, so that they survive a
+	// restart of the client.
+	FileBuffer struct {
+		MemBuffer
+		path string
+	}
+
+	// BufferedDestination holds deliveries while the destination is
+	// unavailable, e.g. refuses connections or answers 502, 503 or 504, and
+	// flushes them in order once a probe finds it up again. Deliveries made
+	// while others are held are held as well, so that order is kept.
+	BufferedDestination struct {
+		Destination
+		Buffer        Buffer
+		ProbeInterval time.Duration
+		// OnHold is called with every held delivery and how many are held.
+		OnHold func(d Delivery, held int)
+		// OnFlush is called with the result of every flushed delivery.
+		OnFlush func(d Delivery, res *Result, err error)
+		// OnFlushed is called once all held deliveries have been flushed.
+		OnFlushed func(flushed int)
+		// OnFlushError is called when a flushed delivery cannot be removed
+		// from the buffer, which stops flushing until the next one is held.
+		OnFlushError func(err error)
+
+		mu       sync.Mutex
+		flushing bool
+	}
+)
+
+func NewMemBuffer() *MemBuffer {
+	return &MemBuffer{Limit: DefaultBufferLimit}
+}
+
+func (b *MemBuffer) Push(d Delivery) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.Limit > 0 && len(b.deliveries) >= b.Limit {
+		return ErrBufferFull
+	}
+	b.deliveries = append(b.deliveries, d)
+	return nil
+}
+
+func (b *MemBuffer) Peek() (Delivery, bool) {
+	b.Lock()
+	defer b.Unlock()
+	if len(b.deliveries) == 0 {
+		return Delivery{}, false
+	}
+	return b.deliveries[0], true
+}
+
+func (b *MemBuffer) Pop() error {
+	b.Lock()
+	defer b.Unlock()
+	if len(b.deliveries) > 0 {
+		b.deliveries = b.deliveries[1:]
+	}
+	return nil
+}
+
+func (b *MemBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.deliveries)
+}
+
+// OpenFileBuffer loads the deliveries held in path, if any.
+func OpenFileBuffer(path string) (*FileBuffer, error) {
+	b := &FileBuffer{MemBuffer: MemBuffer{Limit: DefaultBufferLimit}, path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &b.deliveries); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Push keeps d out of the buffer if it cannot be saved, so that the file and
+// the memory agree on what is held.
+func (b *FileBuffer) Push(d Delivery) error {
+	if err := b.MemBuffer.Push(d); err != nil {
+		return err
+	}
+	if err := b.save(); err != nil {
+		b.Lock()
+		b.deliveries = b.deliveries[:len(b.deliveries)-1]
+		b.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (b *FileBuffer) Pop() error {
+	if err := b.MemBuffer.Pop(); err != nil {
+		return err
+	}
+	return b.save()
+}
+
+// save replaces the file, so that it is never left half written.
+func (b *FileBuffer) save() error {
+	b.Lock()
+	data, err := json.Marshal(b.deliveries)
+	b.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// Unavailable reports whether err means that the destination is down rather
+// than rejecting the delivery.
+func Unavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if e, ok := err.(*StatusError); ok {
+		switch e.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if _, ok := err.(*ExitError); ok {
+		return false
+	}
+	return true
+}
+
+// NewBufferedDestination holds deliveries in memory when buf is nil.
+func NewBufferedDestination(dest Destination, buf Buffer) *BufferedDestination {
+	if buf == nil {
+		buf = NewMemBuffer()
+	}
+	return &BufferedDestination{Destination: dest, Buffer: buf, ProbeInterval: DefaultProbeInterval}
+}
+
+// Deliver returns ErrHeld for held deliveries, which are flushed in the
+// background until ctx is done.
+func (b *BufferedDestination) Deliver(ctx context.Context, d Delivery) (*Result, error) {
+	b.mu.Lock()
+	if b.Buffer.Len() > 0 {
+		defer b.mu.Unlock()
+		return nil, b.hold(ctx, d)
+	}
+	b.mu.Unlock()
+
+	res, err := b.Destination.Deliver(ctx, d)
+	if !Unavailable(err) || ctx.Err() != nil {
+		return res, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return res, b.hold(ctx, d)
+}
+
+// Flush starts flushing deliveries held by a previous run, e.g. in a
+// FileBuffer.
+func (b *BufferedDestination) Flush(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Buffer.Len() > 0 && !b.flushing {
+		b.flushing = true
+		go b.flush(ctx)
+	}
+}
+
+// hold buffers d and makes sure it gets flushed. The caller must hold the
+// lock.
+func (b *BufferedDestination) hold(ctx context.Context, d Delivery) error {
+	if err := b.Buffer.Push(d); err != nil {
+		return err
+	}
+	if b.OnHold != nil {
+		b.OnHold(d, b.Buffer.Len())
+	}
+	if !b.flushing {
+		b.flushing = true
+		go b.flush(ctx)
+	}
+	return ErrHeld
+}
+
+func (b *BufferedDestination) flush(ctx context.Context) {
+	interval := b.ProbeInterval
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	flushed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.flushing = false
+			b.mu.Unlock()
+			return
+		case <-time.After(interval):
+		}
+		if p, ok := b.Destination.(Prober); ok && p.Probe(ctx) != nil {
+			continue
+		}
+
+		for {
+			b.mu.Lock()
+			d, ok := b.Buffer.Peek()
+			if !ok {
+				b.flushing = false
+				b.mu.Unlock()
+				if b.OnFlushed != nil {
+					b.OnFlushed(flushed)
+				}
+				return
+			}
+			b.mu.Unlock()
+
+			res, err := b.Destination.Deliver(ctx, d)
+			if ctx.Err() != nil || Unavailable(err) {
+				break
+			}
+			popErr := b.Buffer.Pop()
+			flushed++
+			if b.OnFlush != nil {
+				b.OnFlush(d, res, err)
+			}
+			if popErr != nil {
+				b.mu.Lock()
+				b.flushing = false
+				b.mu.Unlock()
+				if b.OnFlushError != nil {
+					b.OnFlushError(popErr)
+				}
+				return
+			}
+		}
+	}
+}
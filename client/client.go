@@ -0,0 +1,282 @@
+// Package client subscribes to fbwhs webhooks and forwards their deliveries
+// to local destinations.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"fbwhs/internal"
+)
+
+const (
+	DefaultMinBackoff  = 500 * time.Millisecond
+	DefaultMaxBackoff  = 30 * time.Second
+	DefaultIdleTimeout = 2*internal.PingDelay + 15*time.Second
+)
+
+type (
+	// Delivery is a webhook request received by the relay.
+	Delivery struct {
+		ID       string      `json:"id"`
+		Header   http.Header `json:"header"`
+		Body     string      `json:"body"`
+		Received time.Time   `json:"received"`
+		// WebhookID is the webhook of deliveries received from a stream, see
+		// StreamURL.
+		WebhookID string `json:"webhook_id,omitempty"`
+	}
+
+	// Options tunes a subscription. The zero value is ready to use.
+	Options struct {
+		// Client is used for the SSE connection. It must not have a timeout.
+		Client *http.Client
+		// Header is added to every SSE request.
+		Header http.Header
+		// MinBackoff and MaxBackoff bound the delay between reconnects.
+		MinBackoff time.Duration
+		MaxBackoff time.Duration
+		// IdleTimeout drops the connection if nothing, not even a ping, has
+		// been received for that long.
+		IdleTimeout time.Duration
+		// OnError is called with every connection error before reconnecting.
+		OnError func(err error)
+		// Key, if set, is published with the subscription so that the relay
+		// seals every delivery to it, e.g. an ecdh.X25519 key. The relay then
+		// does not retain the deliveries.
+		Key *ecdh.PrivateKey
+		// Filter has the relay only send the deliveries matching every
+		// expression, e.g. $.entry[*].id=123 or X-App-Id=456, saving the
+		// bandwidth of the others.
+		Filter []string
+	}
+)
+
+// Subscribe connects to a webhook URL such as
+// https://fbwhs.herokuapp.com/webhook/abc123 and streams its deliveries. An
+// error is returned if the first connection fails; afterwards the
+// subscription reconnects with exponential backoff until ctx is done, at
+// which point the channel is closed.
+func Subscribe(ctx context.Context, url string, opts *Options) (<-chan Delivery, error) {
+	o := opts.withDefaults()
+	if o.Key != nil {
+		var err error
+		if url, err = withQuery(url, "key", internal.EncodePublicKey(o.Key.PublicKey())); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.Filter) > 0 {
+		var err error
+		if url, err = withQuery(url, "filter", o.Filter...); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := connect(ctx, url, o)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Delivery)
+	go func() {
+		defer close(ch)
+		for {
+			err := stream(ctx, resp, o, ch)
+			if ctx.Err() != nil {
+				return
+			}
+			o.OnError(err)
+
+			backoff := o.MinBackoff
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				if resp, err = connect(ctx, url, o); err == nil {
+					break
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				o.OnError(err)
+				if backoff *= 2; backoff > o.MaxBackoff {
+					backoff = o.MaxBackoff
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Decode unmarshals the JSON body into v.
+func (d Delivery) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(d.Body), v)
+}
+
+func (d Delivery) Webhook() internal.Webhook {
+	return internal.Webhook{ID: d.ID, Header: d.Header, Body: d.Body}
+}
+
+func (o *Options) withDefaults() Options {
+	var res Options
+	if o != nil {
+		res = *o
+	}
+	if res.Client == nil {
+		res.Client = &http.Client{}
+	}
+	if res.MinBackoff <= 0 {
+		res.MinBackoff = DefaultMinBackoff
+	}
+	if res.MaxBackoff < res.MinBackoff {
+		res.MaxBackoff = DefaultMaxBackoff
+	}
+	if res.IdleTimeout <= 0 {
+		res.IdleTimeout = DefaultIdleTimeout
+	}
+	if res.OnError == nil {
+		res.OnError = func(error) {}
+	}
+	return res
+}
+
+func connect(ctx context.Context, url string, o Options) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range o.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Unable to subscribe, status: %d, %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return resp, nil
+}
+
+// stream reads events until the connection drops and returns why it did.
+func stream(ctx context.Context, resp *http.Response, o Options, ch chan<- Delivery) error {
+	defer resp.Body.Close()
+	idle := time.AfterFunc(o.IdleTimeout, func() { resp.Body.Close() })
+	defer idle.Stop()
+
+	var eventType string
+	var data bytes.Buffer
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if !idle.Stop() {
+				return fmt.Errorf("No event received in %s", o.IdleTimeout)
+			}
+			return fmt.Errorf("Connection lost, error: %s", err.Error())
+		}
+		idle.Reset(o.IdleTimeout)
+
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0:
+			if eventType == "webhook" {
+				d, err := decodeDelivery(data.Bytes(), o.Key)
+				if err != nil {
+					o.OnError(err)
+				} else {
+					select {
+					case ch <- d:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			}
+			eventType = ""
+			data.Reset()
+		case bytes.HasPrefix(line, []byte("event:")):
+			eventType = string(bytes.TrimSpace(line[len("event:"):]))
+		case bytes.HasPrefix(line, []byte("data:")):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(bytes.TrimPrefix(line[len("data:"):], []byte(" ")))
+		}
+	}
+}
+
+func decodeDelivery(data []byte, key *ecdh.PrivateKey) (Delivery, error) {
+	var w internal.Webhook
+	if err := json.Unmarshal(data, &w); err != nil {
+		return Delivery{}, fmt.Errorf("Unable to decode json, error: %s", err.Error())
+	}
+	tag := w.WebhookID
+	if w.Sealed != nil {
+		if key == nil {
+			return Delivery{}, fmt.Errorf("Unable to open sealed delivery %s without a key", w.ID)
+		}
+		opened, err := internal.Open(key, w)
+		if err != nil {
+			return Delivery{}, fmt.Errorf("Unable to open sealed delivery %s, error: %s", w.ID, err.Error())
+		}
+		w = opened
+	}
+	return Delivery{ID: w.ID, Header: w.Header, Body: w.Body, Received: time.Now(), WebhookID: tag}, nil
+}
+
+// StreamURL returns the URL of one stream for the webhooks at srcs, such as
+// https://fbwhs.herokuapp.com/webhook/abc123, which must be served by the
+// same relay. Subscribing to it sets the WebhookID of deliveries to the last
+// path segment of their source, e.g. abc123.
+func StreamURL(srcs ...string) (string, error) {
+	var base string
+	q := make(url.Values)
+	for _, src := range srcs {
+		u, err := url.Parse(src)
+		if err != nil {
+			return "", err
+		}
+		dir, name := path.Split(u.Path)
+		if name == "" || !strings.HasSuffix(dir, "/webhook/") {
+			return "", fmt.Errorf("Invalid webhook address %s", src)
+		}
+		u.Path, u.RawQuery, u.Fragment = strings.TrimSuffix(dir, "webhook/")+"stream", "", ""
+		if base != "" && u.String() != base {
+			return "", fmt.Errorf("Webhooks %s and %s are served by different relays", srcs[0], src)
+		}
+		base = u.String()
+		q.Add("wid", name)
+	}
+	if base == "" {
+		return "", fmt.Errorf("No webhooks to stream")
+	}
+	return base + "?" + q.Encode(), nil
+}
+
+// withQuery sets a query parameter of rawURL.
+func withQuery(rawURL, key string, values ...string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q[key] = values
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
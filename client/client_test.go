@@ -0,0 +1,551 @@
+package client_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fbwhs/client"
+	"fbwhs/internal"
+)
+
+func sseServer(events func(n int32) []internal.Webhook) *httptest.Server {
+	var connections int32
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&connections, 1)
+		rw.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(rw, "event:ping\ndata:ping\n\n")
+		for _, w := range events(n) {
+			b, _ := json.Marshal(w)
+			fmt.Fprintf(rw, "event:webhook\ndata:%s\n\n", b)
+		}
+		rw.(http.Flusher).Flush()
+	}))
+}
+
+func TestSubscribe(t *testing.T) {
+	srv := sseServer(func(n int32) []internal.Webhook {
+		return []internal.Webhook{{ID: fmt.Sprint(n), Body: `{"object":"page"}`}}
+	})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deliveries, err := client.Subscribe(ctx, srv.URL, &client.Options{MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server hangs up after every event, so the second one can only
+	// arrive after reconnecting.
+	for _, id := range []string{"1", "2"} {
+		select {
+		case d := <-deliveries:
+			if d.ID != id || d.Body != `{"object":"page"}` {
+				t.Errorf("Unexpected delivery %+v", d)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Delivery %s not received", id)
+		}
+	}
+
+	cancel()
+	for range deliveries {
+	}
+}
+
+func TestSubscribeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "🤷", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	if _, err := client.Subscribe(context.Background(), srv.URL, nil); err == nil {
+		t.Errorf("Should fail if the first connection fails")
+	}
+}
+
+func TestStreamURL(t *testing.T) {
+	stream, err := client.StreamURL("https://relay.example.com/fbwhs/webhook/a?group=alice", "https://relay.example.com/fbwhs/webhook/b")
+	if err != nil || stream != "https://relay.example.com/fbwhs/stream?wid=a&wid=b" {
+		t.Errorf("Unexpected stream URL %s, %v", stream, err)
+	}
+	if _, err := client.StreamURL("https://relay.example.com/webhook/a", "https://other.example.com/webhook/b"); err == nil {
+		t.Errorf("Webhooks of different relays should be rejected")
+	}
+	if _, err := client.StreamURL("https://relay.example.com/hooks/a"); err == nil {
+		t.Errorf("Addresses that are not webhooks should be rejected")
+	}
+}
+
+func TestHTTPDestination(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/fail" {
+			http.Error(rw, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	d := client.Delivery{ID: "1", Header: http.Header{"X-Hub-Signature": {"sha1=abc"}}, Body: "a body"}
+	dest, _ := client.NewDestination(srv.URL, nil)
+	res, err := dest.Deliver(context.Background(), d)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Delivery should succeed, got %v", err)
+	}
+	if received.Header.Get("X-Hub-Signature") != "sha1=abc" || string(body) != "a body" {
+		t.Errorf("Destination should receive the original request")
+	}
+
+	dest, _ = client.NewDestination(srv.URL+"/fail", nil)
+	res, err = dest.Deliver(context.Background(), d)
+	if _, ok := err.(*client.StatusError); !ok || res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Error status should be reported, got %v", err)
+	}
+
+	if _, err := client.NewDestination("ftp://localhost", nil); err == nil {
+		t.Errorf("Unsupported destinations should be rejected")
+	}
+}
+
+func TestHeaderPolicy(t *testing.T) {
+	in := http.Header{
+		"Connection":          {"close, X-Hop"},
+		"X-Hop":               {"1"},
+		"Content-Length":      {"12"},
+		"Via":                 {"1.1 vegur"},
+		"X-Request-Start":     {"1"},
+		"X-Forwarded-For":     {"203.0.113.1"},
+		"X-Forwarded-Proto":   {"https"},
+		"Content-Type":        {"application/json"},
+		"X-Hub-Signature-256": {"sha256=abc"},
+		"X-Debug":             {"1"},
+	}
+	p := &client.HeaderPolicy{
+		Deny:   []string{"x-debug"},
+		Rename: map[string]string{"X-Hub-Signature-256": "X-Signature"},
+		Set:    http.Header{"X-Env": {"dev"}},
+		Source: "https://fbwhs.herokuapp.com/webhook/abc",
+	}
+	want := http.Header{
+		"Content-Type":      {"application/json"},
+		"X-Signature":       {"sha256=abc"},
+		"X-Env":             {"dev"},
+		"X-Forwarded-For":   {"203.0.113.1"},
+		"X-Forwarded-Host":  {"fbwhs.herokuapp.com"},
+		"X-Forwarded-Proto": {"https"},
+	}
+	if got := p.Apply(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected headers %v", got)
+	}
+
+	p = &client.HeaderPolicy{Allow: []string{"Content-Type"}, OmitForwarded: true}
+	if got := p.Apply(in); !reflect.DeepEqual(got, http.Header{"Content-Type": {"application/json"}}) {
+		t.Errorf("Only allowed headers should be kept, got %v", got)
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	hc, _ := client.NewHTTPClient(client.TLSOptions{}, "", time.Second)
+	if _, err := hc.Get(srv.URL); err == nil {
+		t.Errorf("Unknown CAs should be rejected")
+	}
+	hc, _ = client.NewHTTPClient(client.TLSOptions{Insecure: true}, "", time.Second)
+	if _, err := hc.Get(srv.URL); err != nil {
+		t.Errorf("Insecure clients should accept any certificate, got %v", err)
+	}
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	hc, err := client.NewHTTPClient(client.TLSOptions{CAFile: ca}, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.Get(srv.URL); err != nil {
+		t.Errorf("CAs of the bundle should be trusted, got %v", err)
+	}
+
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	hc, _ = client.NewHTTPClient(client.TLSOptions{}, proxy.URL, time.Second)
+	if _, err := hc.Get("http://fbwhs.example/webhook/abc"); err != nil || proxied != "http://fbwhs.example/webhook/abc" {
+		t.Errorf("Requests should go through the proxy, got %q, %v", proxied, err)
+	}
+	if _, err := client.NewHTTPClient(client.TLSOptions{}, "ftp://proxy", 0); err == nil {
+		t.Errorf("Unsupported proxies should be rejected")
+	}
+}
+
+func TestUnixDestination(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip(err)
+	}
+	var path string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	dest, err := client.NewDestination("unix://"+socket+":/webhook", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := dest.Deliver(context.Background(), client.Delivery{Body: "a"}); err != nil || res.StatusCode != http.StatusOK || path != "/webhook" {
+		t.Errorf("Delivery should reach /webhook over the socket, got %q, %v", path, err)
+	}
+	if _, err := client.NewDestination("unix://"+socket+":webhook", nil); err == nil {
+		t.Errorf("Paths should be absolute")
+	}
+}
+
+func TestExecDestination(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "handle.sh")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$FBWHS_DELIVERY_ID $FBWHS_HEADER_X_HUB_SIGNATURE ${FBWHS_HEADER_PROXY-none} $HTTP_PROXY $(cat)\"\n[ \"$1\" = ok ] || { echo failed >&2; exit 3; }\n"), 0700)
+
+	dest, _ := client.NewDestination("exec:"+script+" ok", nil)
+	d := client.Delivery{ID: "1", Header: http.Header{"X-Hub-Signature": {"sha1=abc"}, "Proxy": {"http://evil:8080"}}, Body: "a body"}
+	t.Setenv("HTTP_PROXY", "")
+	res, err := dest.Deliver(context.Background(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Body) != "1 sha1=abc none  a body\n" || res.Status() != "exit 0" {
+		t.Errorf("Unexpected result %q, %s", res.Body, res.Status())
+	}
+
+	dest, _ = client.NewDestination("exec:"+script, nil)
+	res, err = dest.Deliver(context.Background(), d)
+	if e, ok := err.(*client.ExitError); !ok || e.ExitCode != 3 || string(e.Output) != "failed" || res.ExitCode != 3 {
+		t.Errorf("Exit code should be reported, got %v", err)
+	}
+	if client.Unavailable(err) {
+		t.Errorf("Failed commands should not be held")
+	}
+}
+
+func TestRetryDestination(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch {
+		case r.URL.Path == "/bad":
+			http.Error(rw, "bad", http.StatusBadRequest)
+		case attempts < 3:
+			http.Error(rw, "boom", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	dest, _ := client.NewDestination(srv.URL, nil)
+	retry := &client.RetryDestination{Destination: dest, Retries: 2, Delay: time.Millisecond}
+	if _, err := retry.Deliver(ctx, client.Delivery{}); err != nil || attempts != 3 {
+		t.Errorf("Delivery should succeed after 2 retries, got %v after %d attempts", err, attempts)
+	}
+
+	attempts = 0
+	dest, _ = client.NewDestination(srv.URL+"/bad", nil)
+	retry.Destination = dest
+	if _, err := retry.Deliver(ctx, client.Delivery{}); err == nil || attempts != 1 {
+		t.Errorf("Client errors should not be retried, got %d attempts", attempts)
+	}
+}
+
+func TestBufferedDestination(t *testing.T) {
+	var mu sync.Mutex
+	up := false
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !up {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == "POST" {
+			body, _ := ioutil.ReadAll(r.Body)
+			received = append(received, string(body))
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dest, _ := client.NewDestination(srv.URL, nil)
+	buf, err := client.OpenFileBuffer(filepath.Join(t.TempDir(), "buffer.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := client.NewBufferedDestination(dest, buf)
+	b.ProbeInterval = 10 * time.Millisecond
+	flushed := make(chan int, 1)
+	b.OnFlushed = func(n int) { flushed <- n }
+
+	for _, body := range []string{"1", "2", "3"} {
+		if _, err := b.Deliver(ctx, client.Delivery{Body: body}); err != client.ErrHeld {
+			t.Fatalf("Delivery should be held, got %v", err)
+		}
+	}
+	if buf.Len() != 3 {
+		t.Errorf("Held deliveries should be buffered, got %d", buf.Len())
+	}
+
+	mu.Lock()
+	up = true
+	mu.Unlock()
+	select {
+	case n := <-flushed:
+		if n != 3 {
+			t.Errorf("Expected 3 flushed deliveries, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Deliveries not flushed")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(received, ",") != "1,2,3" {
+		t.Errorf("Deliveries should be flushed in order, got %v", received)
+	}
+}
+
+type failingBuffer struct {
+	*client.MemBuffer
+}
+
+func (b failingBuffer) Pop() error {
+	b.MemBuffer.Pop()
+	return errors.New("Disk full")
+}
+
+func TestBufferErrors(t *testing.T) {
+	buf, err := client.OpenFileBuffer(filepath.Join(t.TempDir(), "missing", "buffer.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buf.Push(client.Delivery{Body: "1"}); err == nil || buf.Len() != 0 {
+		t.Errorf("Unsaved deliveries should not be held, got %v, %d held", err, buf.Len())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	dest, _ := client.NewDestination(srv.URL, nil)
+	b := client.NewBufferedDestination(dest, failingBuffer{client.NewMemBuffer()})
+	b.ProbeInterval = 10 * time.Millisecond
+	flushErr := make(chan error, 1)
+	b.OnFlushError = func(err error) { flushErr <- err }
+	b.Buffer.Push(client.Delivery{Body: "1"})
+	b.Buffer.Push(client.Delivery{Body: "2"})
+	b.Flush(ctx)
+	select {
+	case <-flushErr:
+	case <-time.After(time.Second):
+		t.Fatalf("Buffer errors should be reported")
+	}
+	if b.Buffer.Len() != 1 {
+		t.Errorf("Flushing should stop on buffer errors, got %d held", b.Buffer.Len())
+	}
+}
+
+func TestExport(t *testing.T) {
+	d := client.Delivery{
+		ID: "1",
+		Header: http.Header{
+			"Content-Type":        {"application/json"},
+			"Content-Length":      {"45"},
+			"X-Hub-Signature-256": {"sha256=abc"},
+		},
+		Body: `{"access_token":"EAAB\"x","object":"page"}`,
+	}.Redact()
+	if d.Body != `{"access_token":"REDACTED","object":"page"}` {
+		t.Errorf("Unexpected redacted body %s", d.Body)
+	}
+	if d.Header.Get("X-Hub-Signature-256") != client.Redacted || d.Header.Get("Content-Length") != "43" {
+		t.Errorf("Unexpected redacted headers %v", d.Header)
+	}
+
+	dump, err := d.HTTPDump("http://localhost:4000/hook?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(dump)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if req.Host != "localhost:4000" || req.URL.String() != "/hook?x=1" || string(body) != d.Body {
+		t.Errorf("Unexpected request %+v", req)
+	}
+
+	b, err := d.Fixture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.ReadSession(bytes.NewReader(b))
+	if err != nil || len(session) != 1 || session[0].Body != d.Body {
+		t.Errorf("Fixture should read back, got %v, %v", session, err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, string(body))
+		times = append(times, time.Now())
+	}))
+	defer srv.Close()
+
+	var session bytes.Buffer
+	rec := client.NewRecorder(&session)
+	start := time.Now()
+	for i, body := range []string{"1", "2", "3"} {
+		d := client.Delivery{ID: body, Body: body, Received: start.Add(time.Duration(i) * 50 * time.Millisecond)}
+		if err := rec.Record(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliveries, err := client.ReadSession(&session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 3 || deliveries[2].ID != "3" || !deliveries[2].Received.Equal(start.Add(100*time.Millisecond)) {
+		t.Fatalf("Unexpected session %+v", deliveries)
+	}
+
+	dest, _ := client.NewDestination(srv.URL, nil)
+	results := 0
+	p := &client.Player{Destination: dest, OnResult: func(d client.Delivery, res *client.Result, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			results++
+		}
+	}}
+	if err := p.Play(context.Background(), deliveries); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if strings.Join(received, ",") != "1,2,3" || results != 3 {
+		t.Errorf("Deliveries should be played in order, got %v", received)
+	}
+	received, times = nil, nil
+	mu.Unlock()
+
+	p.Timing = true
+	if err := p.Play(context.Background(), deliveries); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != 3 || times[2].Sub(times[0]) < 90*time.Millisecond {
+		t.Errorf("Timing should be kept, got %v", times)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	rw, err := client.ParseRewrite(`
+		# the test page
+		replace $.entry[*].id "111" "999"
+		delete $.entry[*].time
+		header X-Env: dev
+		split $.entry
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := client.Delivery{ID: "1", Body: `{"object":"page","entry":[{"id":"111","time":1},{"id":"222","time":2}]}`}
+	out, err := rw.Transform(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("Expected one delivery per entry, got %d", len(out))
+	}
+	if out[0].ID != "1-1" || out[0].Body != `{"entry":[{"id":"999"}],"object":"page"}` || out[0].Header.Get("X-Env") != "dev" {
+		t.Errorf("Unexpected first delivery %+v", out[0])
+	}
+	if out[1].ID != "1-2" || out[1].Body != `{"entry":[{"id":"222"}],"object":"page"}` {
+		t.Errorf("Unexpected second delivery %+v", out[1])
+	}
+
+	for _, rule := range []string{"set entry 1", "set $.entry", "move $.entry", "replace $.a 1", "header nope"} {
+		if _, err := client.ParseRewrite(rule); err == nil {
+			t.Errorf("Rule %q should be invalid", rule)
+		}
+	}
+	rw, _ = client.ParseRewrite(`set $.a 1`)
+	if _, err := rw.Transform(client.Delivery{Body: "a=1"}); err != client.ErrNotJSON {
+		t.Errorf("Expected ErrNotJSON, got %v", err)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := client.ParseTemplate(`{"page":{{json (index .JSON.entry 0).id}},"id":"{{.ID}}"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := client.Apply(client.Delivery{ID: "1", Body: `{"entry":[{"id":"123"}]}`}, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].Body != `{"page":"123","id":"1"}` {
+		t.Errorf("Unexpected deliveries %+v", out)
+	}
+}
+
+func TestNotification(t *testing.T) {
+	d := client.Delivery{Body: `{"object":"page","entry":[{"id":"123","time":1,"messaging":[{}]}]}`}
+	n, err := d.Notification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Object != "page" || len(n.Entry) != 1 || n.Entry[0].ID != "123" || len(n.Entry[0].Messaging) != 1 {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+}
+
+func TestCurl(t *testing.T) {
+	d := client.Delivery{
+		Header: http.Header{"Content-Type": {"application/json"}, "Content-Length": {"14"}},
+		Body:   `{"it's":"me"}`,
+	}
+	want := "curl -X POST 'http://localhost:4000/hook' \\\n" +
+		"  -H 'Content-Type: application/json' \\\n" +
+		"  --data-raw '{\"it'\\''s\":\"me\"}'"
+	if got := d.Curl("http://localhost:4000/hook"); got != want {
+		t.Errorf("Unexpected curl command:\n%s", got)
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultForwardTimeout = 10 * time.Second
+	maxResultBody         = 64 << 10
+)
+
+type (
+	// Destination is where deliveries get forwarded to.
+	Destination interface {
+		Deliver(ctx context.Context, d Delivery) (*Result, error)
+	}
+
+	// Result describes how a destination handled a delivery.
+	Result struct {
+		StatusCode int
+		// ExitCode is set instead of StatusCode by ExecDestination.
+		ExitCode int
+		Body     []byte
+		Duration time.Duration
+	}
+
+	// StatusError is returned when the destination answered with an error
+	// status.
+	StatusError struct {
+		StatusCode int
+		Body       []byte
+	}
+
+	// HTTPDestination POSTs deliveries to URL with their original headers,
+	// as filtered by Headers.
+	HTTPDestination struct {
+		URL     string
+		Client  *http.Client
+		Headers *HeaderPolicy
+	}
+)
+
+// NewDestination parses a destination address such as
+// http://localhost:4000/webhook, unix:///tmp/app.sock:/webhook or
+// exec:./handle.sh. A nil client uses one with DefaultForwardTimeout.
+func NewDestination(dest string, client *http.Client) (Destination, error) {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultForwardTimeout}
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &HTTPDestination{URL: dest, Client: client}, nil
+	case "unix":
+		return newUnixDestination(dest, client)
+	case "exec":
+		return newExecDestination(dest)
+	}
+	return nil, fmt.Errorf("Unsupported destination: %s", dest)
+}
+
+func (h *HTTPDestination) Deliver(ctx context.Context, d Delivery) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, strings.NewReader(d.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = h.Headers.Apply(d.Header)
+
+	start := time.Now()
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResultBody))
+	res := &Result{StatusCode: resp.StatusCode, Body: body, Duration: time.Since(start)}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return res, &StatusError{StatusCode: resp.StatusCode, Body: body}
+	}
+	return res, nil
+}
+
+// Probe sends a HEAD request, any response but 502, 503 or 504 meaning that
+// the destination is up.
+func (h *HTTPDestination) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", h.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if err := (&StatusError{StatusCode: resp.StatusCode}); Unavailable(err) {
+		return err
+	}
+	return nil
+}
+
+// Status describes the outcome, e.g. 200 or exit 0.
+func (r *Result) Status() string {
+	if r.StatusCode == 0 {
+		return fmt.Sprintf("exit %d", r.ExitCode)
+	}
+	return fmt.Sprint(r.StatusCode)
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Destination responded with %d: %s", e.StatusCode, e.Body)
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ExecHeaderPrefix is prepended to the headers passed to commands. Unlike
+// CGI's HTTP_, it cannot turn a sender's Proxy header into HTTP_PROXY. The
+// Proxy header is dropped anyway, in case a command maps the names back.
+const ExecHeaderPrefix = "FBWHS_HEADER_"
+
+type (
+	// ExecDestination runs Command for every delivery, with the body on
+	// stdin, and the delivery ID and headers in the environment, e.g.
+	// FBWHS_DELIVERY_ID and FBWHS_HEADER_X_HUB_SIGNATURE_256, as filtered by
+	// Headers. A non-zero exit code fails the delivery.
+	ExecDestination struct {
+		Command string
+		Args    []string
+		Timeout time.Duration
+		Headers *HeaderPolicy
+	}
+
+	// ExitError is returned when the command of an ExecDestination failed.
+	ExitError struct {
+		ExitCode int
+		Output   []byte
+	}
+)
+
+// newUnixDestination parses unix:///tmp/app.sock:/path, with / as the
+// default path, and POSTs to that path over the socket.
+func newUnixDestination(dest string, client *http.Client) (*HTTPDestination, error) {
+	socket := strings.TrimPrefix(dest, "unix://")
+	path := "/"
+	if i := strings.IndexByte(socket, ':'); i >= 0 {
+		socket, path = socket[:i], socket[i+1:]
+	}
+	if socket == "" || !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("Invalid unix destination %s, expected unix:///path/to.sock:/path", dest)
+	}
+
+	var t *http.Transport
+	if base, ok := client.Transport.(*http.Transport); ok {
+		t = base.Clone()
+	} else {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+	c := *client
+	c.Transport = t
+	return &HTTPDestination{URL: "http://localhost" + path, Client: &c}, nil
+}
+
+// newExecDestination parses exec:./handle.sh, with arguments separated by
+// spaces.
+func newExecDestination(dest string) (*ExecDestination, error) {
+	args := strings.Fields(strings.TrimPrefix(dest, "exec:"))
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Invalid exec destination %s, expected exec:command", dest)
+	}
+	return &ExecDestination{Command: args[0], Args: args[1:], Timeout: DefaultForwardTimeout}, nil
+}
+
+func (e *ExecDestination) Deliver(ctx context.Context, d Delivery) (*Result, error) {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Stdin = strings.NewReader(d.Body)
+	cmd.Env = append(os.Environ(), "FBWHS_DELIVERY_ID="+d.ID)
+	for k, v := range e.Headers.Apply(d.Header) {
+		if strings.EqualFold(k, "Proxy") {
+			continue
+		}
+		cmd.Env = append(cmd.Env, ExecHeaderPrefix+strings.ToUpper(strings.Replace(k, "-", "_", -1))+"="+strings.Join(v, ", "))
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	body := stdout.Bytes()
+	if len(body) > maxResultBody {
+		body = body[:maxResultBody]
+	}
+	res := &Result{Body: body, Duration: time.Since(start)}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.ExitCode()
+		return res, &ExitError{ExitCode: res.ExitCode, Output: bytes.TrimSpace(stderr.Bytes())}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("Command exited with %d: %s", e.ExitCode, e.Output)
+}
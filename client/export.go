@@ -0,0 +1,148 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Redacted replaces the value of secret headers and JSON fields.
+const Redacted = "REDACTED"
+
+var (
+	// hopHeaders are set by the HTTP client itself.
+	hopHeaders = map[string]bool{
+		"Accept-Encoding":   true,
+		"Connection":        true,
+		"Content-Length":    true,
+		"Host":              true,
+		"Transfer-Encoding": true,
+	}
+
+	secretHeaders = map[string]bool{
+		"Authorization":       true,
+		"Cookie":              true,
+		"X-Hub-Signature":     true,
+		"X-Hub-Signature-256": true,
+		SecretHeader:          true,
+	}
+
+	// secretField matches JSON string fields such as "access_token": "...".
+	secretField = regexp.MustCompile(`("[^"]*(?i:token|secret|password)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+)
+
+// Redact returns a copy of the delivery without signatures, credentials and
+// JSON fields named like tokens, secrets or passwords.
+func (d Delivery) Redact() Delivery {
+	header := make(http.Header, len(d.Header))
+	for k, v := range d.Header {
+		if secretHeaders[k] {
+			v = []string{Redacted}
+		}
+		header[k] = v
+	}
+	d.Body = secretField.ReplaceAllString(d.Body, `${1}"`+Redacted+`"`)
+	if header.Get("Content-Length") != "" {
+		header.Set("Content-Length", strconv.Itoa(len(d.Body)))
+	}
+	d.Header = header
+	return d
+}
+
+// Curl returns a curl command POSTing the delivery to url.
+func (d Delivery) Curl(url string) string {
+	parts := []string{"curl -X POST " + shellQuote(url)}
+	for _, k := range sortedKeys(d.Header) {
+		if hopHeaders[k] {
+			continue
+		}
+		for _, v := range d.Header[k] {
+			parts = append(parts, "-H "+shellQuote(k+": "+v))
+		}
+	}
+	parts = append(parts, "--data-raw "+shellQuote(d.Body))
+	return strings.Join(parts, " \\\n  ")
+}
+
+// HTTPDump returns the delivery as a raw HTTP/1.1 request to rawURL.
+func (d Delivery) HTTPDump(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "POST %s HTTP/1.1\r\n", u.RequestURI())
+	fmt.Fprintf(&b, "Host: %s\r\n", u.Host)
+	for _, k := range sortedKeys(d.Header) {
+		if hopHeaders[k] {
+			continue
+		}
+		for _, v := range d.Header[k] {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(d.Body))
+	b.WriteString(d.Body)
+	return b.String(), nil
+}
+
+// Fixture returns the delivery as indented JSON, which ReadSession reads
+// back.
+func (d Delivery) Fixture() ([]byte, error) {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// GoRequest returns a Go test helper building an *http.Request for httptest
+// to the path of rawURL from a fixture written by Fixture.
+func GoRequest(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(goRequest, strconv.Quote(u.RequestURI())), nil
+}
+
+const goRequest = `// webhookRequest loads a delivery fixture exported by forward. It needs the
+// encoding/json, net/http, net/http/httptest, os and strings imports.
+func webhookRequest(t *testing.T, fixture string) *http.Request {
+	t.Helper()
+	b, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d struct {
+		Header http.Header ` + "`json:\"header\"`" + `
+		Body   string      ` + "`json:\"body\"`" + `
+	}
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", %s, strings.NewReader(d.Body))
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	return req
+}
+`
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func sortedKeys(h http.Header) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,34 @@
+package client
+
+import "encoding/json"
+
+type (
+	// Notification is the envelope Facebook wraps every webhook payload in.
+	// See https://developers.facebook.com/docs/graph-api/webhooks/getting-started#event-notifications
+	Notification struct {
+		Object string  `json:"object"`
+		Entry  []Entry `json:"entry"`
+	}
+
+	Entry struct {
+		ID        string            `json:"id"`
+		Time      int64             `json:"time"`
+		UID       string            `json:"uid,omitempty"`
+		Messaging []json.RawMessage `json:"messaging,omitempty"`
+		Changes   []Change          `json:"changes,omitempty"`
+	}
+
+	Change struct {
+		Field string          `json:"field"`
+		Value json.RawMessage `json:"value"`
+	}
+)
+
+// Notification decodes the body as a Facebook event notification.
+func (d Delivery) Notification() (*Notification, error) {
+	var n Notification
+	if err := d.Decode(&n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
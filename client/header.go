@@ -0,0 +1,123 @@
+package client
+
+import (
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// DefaultDeniedHeaders are never forwarded: hop-by-hop headers, headers the
+// HTTP client sets itself, and the ones added by the router in front of the
+// relay, e.g. Heroku's. X-Forwarded-* headers are rebuilt instead.
+var DefaultDeniedHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Accept-Encoding",
+	"Content-Length",
+	"Host",
+	"Connect-Time",
+	"Forwarded",
+	"Total-Route-Time",
+	"Via",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Proto",
+	"X-Request-Id",
+	"X-Request-Start",
+}
+
+// HeaderPolicy decides which headers of a delivery are forwarded. The zero
+// value drops DefaultDeniedHeaders and keeps the others.
+type HeaderPolicy struct {
+	// Allow, if not empty, keeps only these headers.
+	Allow []string
+	// Deny drops these headers as well.
+	Deny []string
+	// Rename moves headers to other names, e.g. X-Hub-Signature-256 to
+	// X-Signature.
+	Rename map[string]string
+	// Set replaces or adds headers.
+	Set http.Header
+	// Source is the URL of the webhook, which fills in X-Forwarded-Host and
+	// X-Forwarded-Proto.
+	Source string
+	// OmitForwarded does not add X-Forwarded-For, X-Forwarded-Host and
+	// X-Forwarded-Proto describing the request received by the relay.
+	OmitForwarded bool
+}
+
+// Apply returns the headers to forward. A nil policy is the zero value.
+func (p *HeaderPolicy) Apply(header http.Header) http.Header {
+	if p == nil {
+		p = &HeaderPolicy{}
+	}
+	drop := make(map[string]bool)
+	for _, k := range DefaultDeniedHeaders {
+		drop[k] = true
+	}
+	for _, k := range p.Deny {
+		drop[textproto.CanonicalMIMEHeaderKey(k)] = true
+	}
+	// Connection lists more hop-by-hop headers.
+	for _, v := range header.Values("Connection") {
+		for _, k := range strings.Split(v, ",") {
+			drop[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+	var allow map[string]bool
+	if len(p.Allow) > 0 {
+		allow = make(map[string]bool)
+		for _, k := range p.Allow {
+			allow[textproto.CanonicalMIMEHeaderKey(k)] = true
+		}
+	}
+
+	out := make(http.Header)
+	for k, v := range header {
+		if drop[k] || (allow != nil && !allow[k]) {
+			continue
+		}
+		out[k] = append([]string(nil), v...)
+	}
+	for from, to := range p.Rename {
+		from = textproto.CanonicalMIMEHeaderKey(from)
+		if v, ok := out[from]; ok {
+			delete(out, from)
+			out[textproto.CanonicalMIMEHeaderKey(to)] = v
+		}
+	}
+	if !p.OmitForwarded {
+		p.forwarded(header, out)
+	}
+	for k, v := range p.Set {
+		out[textproto.CanonicalMIMEHeaderKey(k)] = append([]string(nil), v...)
+	}
+	return out
+}
+
+// forwarded describes the request received by the relay, keeping the sender
+// address and protocol recorded by its router, if any.
+func (p *HeaderPolicy) forwarded(in, out http.Header) {
+	if v := in.Values("X-Forwarded-For"); len(v) > 0 {
+		out.Set("X-Forwarded-For", strings.Join(v, ", "))
+	}
+	proto := in.Get("X-Forwarded-Proto")
+	if u, err := url.Parse(p.Source); p.Source != "" && err == nil {
+		out.Set("X-Forwarded-Host", u.Host)
+		if proto == "" {
+			proto = u.Scheme
+		}
+	}
+	if proto != "" {
+		out.Set("X-Forwarded-Proto", proto)
+	}
+}
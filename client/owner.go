@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"fbwhs/internal"
+)
+
+// SecretHeader carries the owner secret of a claimed webhook.
+const SecretHeader = internal.SecretHeader
+
+type secretTransport struct {
+	secret string
+	base   http.RoundTripper
+}
+
+// WithSecret returns a copy of hc that sends the owner secret of claimed
+// webhooks with every request.
+func WithSecret(hc *http.Client, secret string) *http.Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	c := *hc
+	c.Transport = &secretTransport{secret: secret, base: hc.Transport}
+	return &c
+}
+
+func (t *secretTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(SecretHeader, t.secret)
+	return base.RoundTrip(req)
+}
+
+// Claim reserves the webhook at src, so that only the owner of secret can
+// subscribe to it or manage it. It reports whether the webhook was newly
+// claimed; claiming it again with the same secret succeeds.
+func Claim(ctx context.Context, hc *http.Client, src, secret string) (bool, error) {
+	resp, err := do(ctx, WithSecret(hc, secret), "POST", webhookURL(src, "claim"))
+	if err != nil {
+		return false, fmt.Errorf("Failed to claim webhook, error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusOK:
+		return false, nil
+	default:
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return false, fmt.Errorf("Failed to claim webhook: %s", respBody)
+	}
+}
+
+// Release gives up the claim on the webhook at src. Claims do not expire
+// otherwise.
+func Release(ctx context.Context, hc *http.Client, src, secret string) error {
+	resp, err := do(ctx, WithSecret(hc, secret), "DELETE", webhookURL(src, "claim"))
+	if err != nil {
+		return fmt.Errorf("Failed to release webhook, error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to release webhook: %s", respBody)
+	}
+	return nil
+}
+
+// Alias points name at the webhook at src, e.g. so that several callback
+// URLs reach the same subscribers.
+func Alias(ctx context.Context, hc *http.Client, src, name string) error {
+	resp, err := do(ctx, hc, "PUT", webhookURL(src, "aliases/"+name))
+	if err != nil {
+		return fmt.Errorf("Failed to alias webhook, error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to alias webhook: %s", respBody)
+	}
+	return nil
+}
+
+func do(ctx context.Context, hc *http.Client, method, url string) (*http.Response, error) {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return hc.Do(req)
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"fbwhs/internal"
+)
+
+// FetchDelivery retrieves a past delivery of the webhook at src.
+func FetchDelivery(ctx context.Context, hc *http.Client, src, id string) (Delivery, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", deliveryURL(src, id), nil)
+	if err != nil {
+		return Delivery{}, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return Delivery{}, fmt.Errorf("Failed to fetch delivery, error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return Delivery{}, fmt.Errorf("Failed to fetch delivery: %s", respBody)
+	}
+
+	var d internal.Delivery
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return Delivery{}, fmt.Errorf("Unable to decode json, error: %s", err.Error())
+	}
+	return Delivery{ID: d.ID, Header: d.Header, Body: d.Body, Received: d.ReceivedAt}, nil
+}
+
+// Replay asks the server to rebroadcast a past delivery of the webhook at
+// src to every connected subscriber.
+func Replay(ctx context.Context, hc *http.Client, src, id string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", deliveryURL(src, id)+"/replay", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to replay delivery, error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to replay delivery: %s", respBody)
+	}
+	return nil
+}
+
+// AsReplay returns a copy of the delivery marked as a replay of itself.
+func (d Delivery) AsReplay() Delivery {
+	header := d.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(internal.ReplayHeader, d.ID)
+	d.Header = header
+	return d
+}
+
+func deliveryURL(src, id string) string {
+	return webhookURL(src, "deliveries/"+id)
+}
+
+// webhookURL appends elem to the path of the webhook URL src, dropping its
+// query, such as the subscriber group.
+func webhookURL(src, elem string) string {
+	u, err := url.Parse(src)
+	if err != nil {
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(src, "/"), elem)
+	}
+	u.RawQuery = ""
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + elem
+	return u.String()
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const DefaultRetryDelay = time.Second
+
+// RetryDestination retries deliveries that failed with a network error or a
+// 5xx status up to Retries times, waiting Delay before the first retry and
+// twice as long before every next one.
+type RetryDestination struct {
+	Destination
+	Retries int
+	Delay   time.Duration
+}
+
+func (r *RetryDestination) Deliver(ctx context.Context, d Delivery) (*Result, error) {
+	delay := r.Delay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := r.Destination.Deliver(ctx, d)
+		if err == nil || attempt >= r.Retries || !retryable(err) {
+			return res, err
+		}
+		select {
+		case <-ctx.Done():
+			return res, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func retryable(err error) bool {
+	if e, ok := err.(*StatusError); ok {
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+func (r *RetryDestination) Probe(ctx context.Context) error {
+	if p, ok := r.Destination.(Prober); ok {
+		return p.Probe(ctx)
+	}
+	return nil
+}
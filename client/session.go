@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type (
+	// Recorder writes deliveries to a session, one JSON object per line,
+	// with the time they were received.
+	Recorder struct {
+		mu  sync.Mutex
+		enc *json.Encoder
+	}
+
+	// Player sends the deliveries of a recorded session to a destination.
+	Player struct {
+		Destination
+		// Timing keeps the original gaps between deliveries, sending each
+		// one on time even if earlier ones are still in flight, so that
+		// bursts are reproduced. Otherwise deliveries are sent one after the
+		// other as fast as possible.
+		Timing bool
+		// OnResult is called with the result of every delivery.
+		OnResult func(d Delivery, res *Result, err error)
+	}
+)
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+func (r *Recorder) Record(d Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(d)
+}
+
+// ReadSession reads the deliveries of a session written by a Recorder.
+func ReadSession(r io.Reader) ([]Delivery, error) {
+	var session []Delivery
+	dec := json.NewDecoder(r)
+	for {
+		var d Delivery
+		err := dec.Decode(&d)
+		if err == io.EOF {
+			return session, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode delivery %d of the session, error: %s", len(session)+1, err.Error())
+		}
+		session = append(session, d)
+	}
+}
+
+// Play returns once every delivery has been sent, or ctx is done.
+func (p *Player) Play(ctx context.Context, session []Delivery) error {
+	var wg sync.WaitGroup
+	var first time.Time
+	start := time.Now()
+	for _, d := range session {
+		if first.IsZero() {
+			first = d.Received
+		}
+		if p.Timing && !d.Received.IsZero() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(start.Add(d.Received.Sub(first)))):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if !p.Timing {
+			p.deliver(ctx, d)
+			continue
+		}
+		wg.Add(1)
+		go func(d Delivery) {
+			defer wg.Done()
+			p.deliver(ctx, d)
+		}(d)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (p *Player) deliver(ctx context.Context, d Delivery) {
+	res, err := p.Destination.Deliver(ctx, d)
+	if p.OnResult != nil {
+		p.OnResult(d, res, err)
+	}
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"fbwhs/internal"
+)
+
+// Status is the activity of a webhook as seen by the relay instance
+// answering: its subscribers, deliveries, last handshake with the provider,
+// pushes waiting for a retry and errors by kind.
+type Status = internal.Status
+
+// Verification is the last handshake of a webhook with the provider.
+type Verification = internal.Verification
+
+// FetchStatus retrieves the status of the webhook at src.
+func FetchStatus(ctx context.Context, hc *http.Client, src string) (Status, error) {
+	resp, err := do(ctx, hc, "GET", webhookURL(src, "status"))
+	if err != nil {
+		return Status{}, fmt.Errorf("Failed to fetch status, error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return Status{}, fmt.Errorf("Failed to fetch status: %s", respBody)
+	}
+
+	var st Status
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return Status{}, fmt.Errorf("Unable to decode json, error: %s", err.Error())
+	}
+	return st, nil
+}
@@ -0,0 +1,360 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"fbwhs/internal"
+)
+
+var ErrNotJSON = errors.New("Unable to rewrite a body that is not JSON")
+
+type (
+	// Transform rewrites a delivery before it is forwarded, possibly into
+	// several deliveries.
+	Transform interface {
+		Transform(d Delivery) ([]Delivery, error)
+	}
+
+	// Rewrite applies rules to the JSON body and headers of deliveries. Paths
+	// start at the root $ and select object fields with .name and array
+	// elements with [0] or [*]:
+	//
+	//	set $.entry[*].id "123"            sets a value, given as JSON
+	//	replace $.entry[*].id "456" "123"  sets it only where it equals the first value
+	//	delete $.entry[*].time             deletes a value
+	//	header X-Env: dev                  sets a header, or deletes it if empty
+	//	split $.entry                      sends one delivery per array element
+	Rewrite struct {
+		rules []rule
+	}
+
+	rule struct {
+		op       string
+		path     []internal.PathStep
+		from, to interface{}
+		header   string
+		value    string
+	}
+
+	// Template replaces the body of deliveries with the output of a Go
+	// text/template. The template gets the ID, Header and Body of the
+	// delivery, and JSON, its decoded body. The json function encodes a
+	// value back to JSON.
+	Template struct {
+		t *template.Template
+	}
+)
+
+// ParseRewrite parses rules, one per line. Blank lines and lines starting
+// with # are ignored.
+func ParseRewrite(rules ...string) (*Rewrite, error) {
+	rw := &Rewrite{}
+	for _, text := range rules {
+		s := bufio.NewScanner(strings.NewReader(text))
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			r, err := parseRule(line)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rewrite rule \"%s\": %s", line, err.Error())
+			}
+			rw.rules = append(rw.rules, r)
+		}
+	}
+	return rw, nil
+}
+
+func parseRule(line string) (rule, error) {
+	op, rest := cutSpace(line)
+	r := rule{op: op}
+	if r.op == "header" {
+		h := strings.SplitN(rest, ":", 2)
+		if len(h) != 2 || strings.TrimSpace(h[0]) == "" {
+			return r, errors.New("expected header Name: value")
+		}
+		r.header = http.CanonicalHeaderKey(strings.TrimSpace(h[0]))
+		r.value = strings.TrimSpace(h[1])
+		return r, nil
+	}
+	p, rest := cutSpace(rest)
+	if p == "" {
+		return r, errors.New("missing path")
+	}
+	path, err := internal.ParsePath(p)
+	if err != nil {
+		return r, err
+	}
+	r.path = path
+	args, err := jsonValues(rest)
+	if err != nil {
+		return r, err
+	}
+
+	switch r.op {
+	case "set":
+		if len(args) != 1 {
+			return r, errors.New("expected one JSON value")
+		}
+		r.to = args[0]
+	case "replace":
+		if len(args) != 2 {
+			return r, errors.New("expected two JSON values")
+		}
+		r.from, r.to = args[0], args[1]
+	case "delete", "split":
+		if len(args) != 0 {
+			return r, errors.New("unexpected value")
+		}
+	default:
+		return r, fmt.Errorf("unknown operation %s", r.op)
+	}
+	return r, nil
+}
+
+// cutSpace splits s at the first run of spaces.
+func cutSpace(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+func jsonValues(s string) ([]interface{}, error) {
+	var values []interface{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON value, %s", err.Error())
+		}
+		values = append(values, v)
+	}
+}
+
+func (rw *Rewrite) Transform(d Delivery) ([]Delivery, error) {
+	out := []Delivery{d}
+	var docs []interface{}
+	for _, r := range rw.rules {
+		if r.op == "header" {
+			for i := range out {
+				out[i].Header = withHeader(out[i].Header, r.header, r.value)
+			}
+			continue
+		}
+		if docs == nil {
+			doc, err := decodeJSON(d.Body)
+			if err != nil {
+				return nil, err
+			}
+			docs = []interface{}{doc}
+		}
+
+		if r.op != "split" {
+			for i := range docs {
+				docs[i] = update(docs[i], r.path, r.apply)
+			}
+			continue
+		}
+		var split []interface{}
+		var deliveries []Delivery
+		for i, doc := range docs {
+			for _, part := range r.split(doc) {
+				split = append(split, part)
+				deliveries = append(deliveries, out[i])
+			}
+		}
+		docs, out = split, deliveries
+	}
+	if docs == nil {
+		return out, nil
+	}
+
+	for i := range out {
+		b, err := json.Marshal(docs[i])
+		if err != nil {
+			return nil, err
+		}
+		out[i].Body = string(b)
+		if len(out) > 1 {
+			out[i].ID = fmt.Sprintf("%s-%d", d.ID, i+1)
+		}
+	}
+	return out, nil
+}
+
+// apply is called with the values the rule path points to.
+func (r rule) apply(old interface{}, ok bool) (interface{}, bool) {
+	switch r.op {
+	case "set":
+		return r.to, true
+	case "replace":
+		if ok && sameJSON(old, r.from) {
+			return r.to, true
+		}
+	case "delete":
+		return nil, false
+	}
+	return old, ok
+}
+
+// split returns a copy of doc per element of the array at the rule path,
+// holding only that element.
+func (r rule) split(doc interface{}) []interface{} {
+	var elements []interface{}
+	update(doc, r.path, func(old interface{}, ok bool) (interface{}, bool) {
+		if a, isArray := old.([]interface{}); isArray && elements == nil {
+			elements = a
+		}
+		return old, ok
+	})
+	if len(elements) == 0 {
+		return []interface{}{doc}
+	}
+	b, _ := json.Marshal(doc)
+	parts := make([]interface{}, len(elements))
+	for i := range elements {
+		part, _ := decodeJSON(string(b))
+		parts[i] = update(part, r.path, func(old interface{}, ok bool) (interface{}, bool) {
+			if a, isArray := old.([]interface{}); isArray && i < len(a) {
+				return []interface{}{a[i]}, true
+			}
+			return old, ok
+		})
+	}
+	return parts
+}
+
+// update calls fn with every value path points to in v, and replaces it with
+// what fn returns, deleting it if fn returns false.
+func update(v interface{}, path []internal.PathStep, fn func(old interface{}, ok bool) (interface{}, bool)) interface{} {
+	s := path[0]
+	switch c := v.(type) {
+	case map[string]interface{}:
+		if s.Array {
+			return v
+		}
+		old, ok := c[s.Key]
+		if len(path) > 1 {
+			if ok {
+				c[s.Key] = update(old, path[1:], fn)
+			}
+			return c
+		}
+		if value, keep := fn(old, ok); keep {
+			c[s.Key] = value
+		} else {
+			delete(c, s.Key)
+		}
+	case []interface{}:
+		if !s.Array {
+			return v
+		}
+		out := make([]interface{}, 0, len(c))
+		for i, e := range c {
+			switch {
+			case !s.All && i != s.Index:
+				out = append(out, e)
+			case len(path) > 1:
+				out = append(out, update(e, path[1:], fn))
+			default:
+				if value, keep := fn(e, true); keep {
+					out = append(out, value)
+				}
+			}
+		}
+		return out
+	}
+	return v
+}
+
+func decodeJSON(body string) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, ErrNotJSON
+	}
+	return v, nil
+}
+
+func sameJSON(a, b interface{}) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return bytes.Equal(ab, bb)
+}
+
+func withHeader(h http.Header, key, value string) http.Header {
+	header := h.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if value == "" {
+		header.Del(key)
+	} else {
+		header.Set(key, value)
+	}
+	return header
+}
+
+func ParseTemplate(text string) (*Template, error) {
+	t, err := template.New("transform").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{t: t}, nil
+}
+
+func (t *Template) Transform(d Delivery) ([]Delivery, error) {
+	data := struct {
+		ID     string
+		Header http.Header
+		Body   string
+		JSON   interface{}
+	}{ID: d.ID, Header: d.Header, Body: d.Body}
+	data.JSON, _ = decodeJSON(d.Body)
+
+	var body bytes.Buffer
+	if err := t.t.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	d.Body = body.String()
+	return []Delivery{d}, nil
+}
+
+// Apply runs the delivery through every transform in order.
+func Apply(d Delivery, transforms ...Transform) ([]Delivery, error) {
+	out := []Delivery{d}
+	for _, t := range transforms {
+		var next []Delivery
+		for _, d := range out {
+			res, err := t.Transform(d)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, res...)
+		}
+		out = next
+	}
+	return out, nil
+}
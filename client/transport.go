@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TLSOptions configures TLS for the SSE source or a destination. The zero
+// value uses the system CAs.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of CAs trusted on top of the system ones.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and its key, sent
+	// to servers asking for one.
+	CertFile string
+	KeyFile  string
+	// Insecure skips verifying the server certificate, e.g. a self-signed
+	// one on localhost.
+	Insecure bool
+}
+
+// Config returns the TLS configuration, or nil for the defaults.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	if o == (TLSOptions{}) {
+		return nil, nil
+	}
+	c := &tls.Config{InsecureSkipVerify: o.Insecure}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if c.RootCAs, err = x509.SystemCertPool(); err != nil {
+			c.RootCAs = x509.NewCertPool()
+		}
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", o.CAFile)
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// NewHTTPClient returns a client with the TLS options, going through proxy,
+// e.g. http://proxy:3128 or socks5://localhost:1080, or else through the
+// proxy set in the environment. Clients for Subscribe must have no timeout.
+func NewHTTPClient(o TLSOptions, proxy string, timeout time.Duration) (*http.Client, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	var err error
+	if t.TLSClientConfig, err = o.Config(); err != nil {
+		return nil, err
+	}
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("Unsupported proxy: %s", proxy)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Transport: t, Timeout: timeout}, nil
+}
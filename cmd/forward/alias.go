@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"fbwhs/client"
+)
+
+func alias(arguments []string) {
+	fs := flag.NewFlagSet("alias", flag.ExitOnError)
+	fs.StringVar(&src, "src", "", "Webhook SSE source")
+	fs.StringVar(&src, "s", "", "Webhook SSE source")
+	stateFlags(fs)
+	fs.Parse(arguments)
+
+	args := fs.Args()
+	lastSource()
+	if src == "" || len(args) != 1 {
+		fmt.Println("Error: -src and <name> are required")
+		fmt.Println()
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+
+	hc := &http.Client{Timeout: client.DefaultForwardTimeout}
+	if secret != "" {
+		hc = client.WithSecret(hc, secret)
+	}
+	if err := client.Alias(context.Background(), hc, src, args[0]); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("\"%s\" now points to \"%s\"\n", args[0], src)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"fbwhs/client"
+	"gopkg.in/ini.v1"
+)
+
+// loadConfig reads the routes of an ini file, one section per route. Keys of
+// the default section apply to every route:
+//
+//	encrypt = true
+//
+//	[messenger]
+//	src = https://fbwhs.herokuapp.com/webhook/team-messenger
+//	dest = http://localhost:4000/messenger
+//	object = page
+//	field = messages
+//	header = X-Env: dev
+//	rewrite = set $.entry[*].id "123"
+//	retries = 3
+func loadConfig(path string) ([]*route, error) {
+	f, err := ini.ShadowLoad(path)
+	if err != nil {
+		return nil, err
+	}
+	def := f.Section(ini.DefaultSection)
+
+	var routes []*route
+	for _, s := range f.Sections() {
+		if s.Name() == ini.DefaultSection {
+			continue
+		}
+		r, err := parseRoute(s, def)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid route [%s]: %s", s.Name(), err.Error())
+		}
+		routes = append(routes, r)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("No routes in %s", path)
+	}
+	return routes, nil
+}
+
+func parseRoute(s, def *ini.Section) (*route, error) {
+	key := func(name string) *ini.Key {
+		if k, err := s.GetKey(name); err == nil {
+			return k
+		}
+		if k, err := def.GetKey(name); err == nil {
+			return k
+		}
+		return nil
+	}
+	value := func(name, fallback string) string {
+		if k := key(name); k != nil {
+			return k.String()
+		}
+		return fallback
+	}
+
+	r := &route{
+		name:       s.Name(),
+		src:        value("src", ""),
+		dest:       value("dest", ""),
+		group:      value("group", ""),
+		bufferFile: value("buffer_file", ""),
+		proxy:      value("proxy", ""),
+		tls: client.TLSOptions{
+			CAFile:   value("ca", ""),
+			CertFile: value("cert", ""),
+			KeyFile:  value("key", ""),
+		},
+		secret:     value("secret", os.Getenv("FBWHS_SECRET")),
+		buffer:     true,
+		retryDelay: client.DefaultRetryDelay,
+	}
+	if r.src == "" || r.dest == "" {
+		return nil, fmt.Errorf("src and dest are required")
+	}
+	if k := key("object"); k != nil {
+		r.objects = k.Strings(",")
+	}
+	if k := key("field"); k != nil {
+		r.fields = k.Strings(",")
+	}
+	values := func(name string) []string {
+		if k := key(name); k != nil {
+			return k.ValueWithShadows()
+		}
+		return nil
+	}
+	var err error
+	r.headers, err = headerPolicy(values("header"), values("allow_header"), values("deny_header"), values("rename_header"))
+	if err != nil {
+		return nil, err
+	}
+
+	r.filter = values("filter")
+
+	var rules []string
+	if k := key("rewrite"); k != nil {
+		rules = k.ValueWithShadows()
+	}
+	if r.transforms, err = parseTransforms(rules, value("template", "")); err != nil {
+		return nil, err
+	}
+	if r.srcHeader, err = parseHeaders(values("src_header")); err != nil {
+		return nil, err
+	}
+	if k := key("insecure"); k != nil {
+		if r.insecure, err = k.Bool(); err != nil {
+			return nil, fmt.Errorf("insecure: %s", err.Error())
+		}
+	}
+	if k := key("forwarded"); k != nil {
+		forwarded, err := k.Bool()
+		if err != nil {
+			return nil, fmt.Errorf("forwarded: %s", err.Error())
+		}
+		r.headers.OmitForwarded = !forwarded
+	}
+	if k := key("encrypt"); k != nil {
+		if r.encrypt, err = k.Bool(); err != nil {
+			return nil, fmt.Errorf("encrypt: %s", err.Error())
+		}
+	}
+	if k := key("buffer"); k != nil {
+		if r.buffer, err = k.Bool(); err != nil {
+			return nil, fmt.Errorf("buffer: %s", err.Error())
+		}
+	}
+	if k := key("retries"); k != nil {
+		if r.retries, err = k.Int(); err != nil {
+			return nil, fmt.Errorf("retries: %s", err.Error())
+		}
+	}
+	if k := key("retry_delay"); k != nil {
+		if r.retryDelay, err = k.Duration(); err != nil {
+			return nil, fmt.Errorf("retry_delay: %s", err.Error())
+		}
+	}
+	return r, nil
+}
+
+// headerPolicy parses "Name: value" headers to set, comma separated lists of
+// headers to allow or deny, and "From: To" renames.
+func headerPolicy(set, allow, deny, rename []string) (client.HeaderPolicy, error) {
+	p := client.HeaderPolicy{Rename: make(map[string]string)}
+	var err error
+	if p.Set, err = parseHeaders(set); err != nil {
+		return p, err
+	}
+	for _, h := range rename {
+		from, to, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(to) == "" {
+			return p, fmt.Errorf("rename must look like \"From: To\", got %q", h)
+		}
+		p.Rename[strings.TrimSpace(from)] = strings.TrimSpace(to)
+	}
+	p.Allow = splitList(allow)
+	p.Deny = splitList(deny)
+	return p, nil
+}
+
+// parseHeaders parses "Name: value" headers.
+func parseHeaders(values []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, h := range values {
+		name, v, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("header must look like \"Name: value\", got %q", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(v))
+	}
+	return header, nil
+}
+
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"fbwhs/client"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+		check  func(t *testing.T, routes []*route)
+	}{
+		{
+			name: "default section",
+			config: `
+dest = http://localhost:4000
+retries = 2
+encrypt = true
+filter = $.object == "page"
+
+[a]
+src = https://relay/webhook/a
+
+[b]
+src = https://relay/webhook/b
+dest = http://localhost:5000
+retries = 0
+`,
+			check: func(t *testing.T, routes []*route) {
+				if len(routes) != 2 || routes[0].name != "a" || routes[1].name != "b" {
+					t.Fatalf("Expected routes a and b, got %d", len(routes))
+				}
+				a, b := routes[0], routes[1]
+				if a.dest != "http://localhost:4000" || a.retries != 2 || !a.encrypt || len(a.filter) != 1 {
+					t.Errorf("Routes should inherit the default section, got %+v", a)
+				}
+				if b.dest != "http://localhost:5000" || b.retries != 0 || !b.encrypt {
+					t.Errorf("Routes should override the default section, got %+v", b)
+				}
+				if !a.buffer || a.retryDelay <= 0 {
+					t.Errorf("Unset keys should keep their defaults, got %+v", a)
+				}
+			},
+		},
+		{
+			name: "shadowed keys",
+			config: `
+header = X-Default: 1
+rewrite = delete $.default
+
+[a]
+src = https://relay/webhook/a
+dest = http://localhost:4000
+header = X-Env: dev
+header = X-Team: alice
+rewrite = delete $.entry
+rewrite = set $.object "page"
+filter = $.object == "page"
+filter = header.X-Env == "dev"
+object = page,user
+retry_delay = 250ms
+`,
+			check: func(t *testing.T, routes []*route) {
+				r := routes[0]
+				if r.headers.Set.Get("X-Env") != "dev" || r.headers.Set.Get("X-Team") != "alice" || r.headers.Set.Get("X-Default") != "" {
+					t.Errorf("Every header of the route should replace the default ones, got %v", r.headers.Set)
+				}
+				deliveries, err := client.Apply(client.Delivery{Body: `{"default":1,"entry":[],"object":"user"}`}, r.transforms...)
+				var body map[string]interface{}
+				if err == nil {
+					err = json.Unmarshal([]byte(deliveries[0].Body), &body)
+				}
+				if err != nil || !reflect.DeepEqual(body, map[string]interface{}{"default": 1.0, "object": "page"}) {
+					t.Errorf("Every rewrite of the route should replace the default ones, got %v, %v", body, err)
+				}
+				if len(r.filter) != 2 {
+					t.Errorf("Every filter should be kept, got %v", r.filter)
+				}
+				if !reflect.DeepEqual(r.objects, []string{"page", "user"}) || r.retryDelay != 250*time.Millisecond {
+					t.Errorf("Unexpected route %+v", r)
+				}
+			},
+		},
+		{name: "missing dest", config: "[a]\nsrc = https://relay/webhook/a\n", err: "src and dest are required"},
+		{name: "no routes", config: "dest = http://localhost:4000\n", err: "No routes"},
+		{name: "bool", config: "[a]\nsrc = s\ndest = d\nencrypt = maybe\n", err: "encrypt:"},
+		{name: "int", config: "[a]\nsrc = s\ndest = d\nretries = many\n", err: "retries:"},
+		{name: "duration", config: "[a]\nsrc = s\ndest = d\nretry_delay = 5\n", err: "retry_delay:"},
+		{name: "header", config: "[a]\nsrc = s\ndest = d\nheader = X-Env\n", err: "Name: value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "forward.ini")
+			if err := ioutil.WriteFile(path, []byte(test.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			routes, err := loadConfig(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected an error with %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, routes)
+		})
+	}
+}
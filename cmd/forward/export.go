@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fbwhs/client"
+)
+
+func export(arguments []string) {
+	var in, format, dest, testdata string
+	var redact bool
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&src, "src", "", "Webhook SSE source")
+	fs.StringVar(&src, "s", "", "Webhook SSE source")
+	fs.StringVar(&in, "in", "", "Session or fixture file")
+	fs.StringVar(&format, "format", "curl", "curl, go or http")
+	fs.StringVar(&dest, "dest", "http://localhost:4000/", "Destination of the request")
+	fs.StringVar(&testdata, "testdata", "testdata", "Fixtures directory")
+	fs.BoolVar(&redact, "redact", false, "Redact secrets")
+	stateFlags(fs)
+	fs.Parse(arguments)
+
+	args := fs.Args()
+	if in == "" {
+		lastSource()
+	}
+	if (in == "" && (src == "" || len(args) != 1)) || len(args) > 1 {
+		fmt.Println("Error: -src and <id>, or -in are required")
+		fmt.Println()
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	var id string
+	if len(args) == 1 {
+		id = args[0]
+	}
+
+	deliveries, err := exported(in, id)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if format == "go" {
+		err = exportGo(deliveries, redact, dest, testdata)
+	} else {
+		for i, d := range deliveries {
+			if redact {
+				d = d.Redact()
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			if err = exportDelivery(d, format, dest); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// exported returns the delivery with the given ID, from the server or from
+// a session or fixture file, or all the deliveries of the file if there is
+// no ID.
+func exported(in, id string) ([]client.Delivery, error) {
+	if in == "" {
+		hc := &http.Client{Timeout: client.DefaultForwardTimeout}
+		if secret != "" {
+			hc = client.WithSecret(hc, secret)
+		}
+		d, err := client.FetchDelivery(context.Background(), hc, src, id)
+		if err != nil {
+			return nil, err
+		}
+		return []client.Delivery{d}, nil
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	session, err := client.ReadSession(f)
+	if err != nil || id == "" {
+		return session, err
+	}
+	for _, d := range session {
+		if d.ID == id {
+			return []client.Delivery{d}, nil
+		}
+	}
+	return nil, fmt.Errorf("Delivery %s not found in \"%s\"", id, in)
+}
+
+func exportDelivery(d client.Delivery, format, dest string) error {
+	switch format {
+	case "curl":
+		fmt.Println(d.Curl(dest))
+	case "http":
+		dump, err := d.HTTPDump(dest)
+		if err != nil {
+			return err
+		}
+		fmt.Println(dump)
+	default:
+		return fmt.Errorf("Unknown format \"%s\", expected curl, go or http", format)
+	}
+	return nil
+}
+
+// exportGo saves the deliveries as fixtures and prints a test helper
+// loading them.
+func exportGo(deliveries []client.Delivery, redact bool, dest, testdata string) error {
+	helper, err := client.GoRequest(dest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(testdata, 0755); err != nil {
+		return err
+	}
+	var calls []string
+	for _, d := range deliveries {
+		if redact {
+			d = d.Redact()
+		}
+		b, err := d.Fixture()
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(testdata, d.ID+".json")
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			return err
+		}
+		calls = append(calls, fmt.Sprintf("// req := webhookRequest(t, %q)", filepath.ToSlash(path)))
+	}
+	fmt.Printf("%s\n%s\n", helper, strings.Join(calls, "\n"))
+	return nil
+}
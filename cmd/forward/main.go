@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"fbwhs/client"
+	"github.com/segmentio/ksuid"
+)
+
+const usage = `forward.
+
+Usage:
+  forward [options] <dest>
+  forward -config <file>
+  forward replay [options] <id> [<dest>]
+  forward alias [options] <name>
+  forward record [options] -out <session>
+  forward play [-timing] <session> <dest>
+  forward export [options] [-in <session>] [<id>]
+
+Commands:
+  replay         Replays a past delivery through the server, or straight into <dest> if given.
+  alias          Points <name> at the webhook, e.g. https://fbwhs.herokuapp.com/webhook/<name>.
+  record         Appends every delivery to <session>, one JSON object per line, with the time
+                 it was received.
+  play           Sends the deliveries of <session> to <dest>, one after the other as fast as
+                 possible, or with their original timing if -timing is set.
+  export         Prints a past delivery, or those of a session or fixture file given with -in,
+                 as a curl command (-format curl), a raw HTTP/1.1 request (-format http), or
+                 saves it as a fixture in -testdata and prints a Go test helper loading it
+                 (-format go). -dest sets the URL of the request and -redact hides signatures,
+                 credentials and JSON fields named like tokens, secrets or passwords.
+
+Destinations:
+  http://localhost:4000/webhook    POSTs deliveries with their headers.
+  unix:///tmp/app.sock:/webhook    POSTs them to /webhook over a Unix domain socket.
+  exec:./handle.sh                 Runs the command with the body on stdin, and the delivery ID
+                                   and headers in the environment, e.g. FBWHS_DELIVERY_ID and
+                                   FBWHS_HEADER_X_HUB_SIGNATURE_256. A non-zero exit code fails it.
+
+Options:
+  -s -src        Webhook SSE source address. E.g. https://fbwhs.herokuapp.com/webhook/fb-callback
+                 Defaults to the last one used, or a new random webhook.
+  -g -group      Only receive the deliveries routed to this subscriber group.
+  -filter        Only receive the deliveries matching a JSON path or header, e.g.
+                 -filter '$.entry[*].id=123' or -filter 'X-App-Id=456'. Can be repeated.
+  -secret        Claims the webhook so that only the owner of the secret can subscribe to it.
+                 Defaults to $FBWHS_SECRET, or the last one used with the same source.
+  -state         File remembering the last source and secret.
+  -buffer        Holds deliveries while <dest> is down and flushes them once it is back. [default: true]
+  -buffer-file   Holds them in this file rather than in memory, so that they survive a restart.
+  -tui           Shows deliveries in an interactive terminal UI, where they can be replayed,
+                 copied as curl or saved as fixtures.
+  -fixtures      Directory the terminal UI saves fixtures to. [default: fixtures]
+  -header        Sets a header, e.g. -header 'X-Env: dev'. Can be repeated.
+  -allow-header  Only forwards these comma separated headers.
+  -deny-header   Drops these comma separated headers, on top of hop-by-hop, Content-Length,
+                 Accept-Encoding and router headers such as Via or X-Request-Start.
+  -rename-header Renames a header, e.g. -rename-header 'X-Hub-Signature-256: X-Signature'.
+                 Can be repeated.
+  -forwarded     Adds X-Forwarded-For, -Host and -Proto describing the request received by
+                 the server. [default: true]
+  -src-header    Sets a header on the SSE request, e.g. -src-header 'Authorization: Bearer ...'.
+                 Can be repeated.
+  -proxy         HTTP or SOCKS proxy for the SSE source, e.g. socks5://localhost:1080.
+                 Defaults to $HTTPS_PROXY or $HTTP_PROXY.
+  -ca            PEM bundle of CAs trusted on top of the system ones.
+  -cert -key     PEM client certificate and key, for servers asking for one.
+  -insecure      Skips verifying the certificate of <dest>, e.g. a self-signed one.
+  -rewrite       Rewrites the JSON body or headers before forwarding, e.g.
+                 -rewrite 'set $.entry[*].id "123"'. Can be repeated. See the README for the rules.
+  -template      Replaces the body with the output of this Go text/template file.
+  -config        Runs every route of an ini file, with its own source, destination, filters,
+                 headers and retries. See the README for the format.
+  -encrypt       Has the server seal deliveries to a key generated for this run. The server
+                 then stops keeping the deliveries of the webhook, so that they cannot be
+                 replayed or exported.
+`
+
+var (
+	src, group, secret, statePath, configPath, bufferFile, fixtures string
+	templatePath, proxy, caFile, certFile, keyFile                  string
+	encrypt, buffer, interactive, forwarded, insecure               bool
+	rewrite, setHeader, allowHeader, denyHeader, renameHeader       listFlag
+	srcHeader, filter                                               listFlag
+)
+
+// listFlag collects the values of a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func init() {
+	flag.StringVar(&src, "src", "", "Webhook SSE source")
+	flag.StringVar(&src, "s", "", "Webhook SSE source")
+	flag.StringVar(&group, "group", "", "Subscriber group")
+	flag.StringVar(&group, "g", "", "Subscriber group")
+	flag.Var(&filter, "filter", "Server side filter")
+	flag.BoolVar(&encrypt, "encrypt", false, "Seal deliveries")
+	flag.BoolVar(&buffer, "buffer", true, "Hold deliveries while the destination is down")
+	flag.StringVar(&bufferFile, "buffer-file", "", "Buffer file")
+	flag.StringVar(&configPath, "config", "", "Routes config file")
+	flag.BoolVar(&interactive, "tui", false, "Terminal UI")
+	flag.StringVar(&fixtures, "fixtures", "fixtures", "Fixtures directory")
+	flag.Var(&rewrite, "rewrite", "Rewrite rule")
+	flag.Var(&setHeader, "header", "Header to set")
+	flag.Var(&allowHeader, "allow-header", "Headers to forward")
+	flag.Var(&denyHeader, "deny-header", "Headers to drop")
+	flag.Var(&renameHeader, "rename-header", "Header to rename")
+	flag.BoolVar(&forwarded, "forwarded", true, "Add X-Forwarded-* headers")
+	flag.Var(&srcHeader, "src-header", "Header of the SSE request")
+	flag.StringVar(&proxy, "proxy", "", "Proxy for the SSE source")
+	flag.StringVar(&caFile, "ca", "", "CA bundle")
+	flag.StringVar(&certFile, "cert", "", "Client certificate")
+	flag.StringVar(&keyFile, "key", "", "Client certificate key")
+	flag.BoolVar(&insecure, "insecure", false, "Skip verifying the destination certificate")
+	flag.StringVar(&templatePath, "template", "", "Body template file")
+	stateFlags(flag.CommandLine)
+}
+
+// stateFlags registers the flags shared by every command.
+func stateFlags(fs *flag.FlagSet) {
+	fs.StringVar(&secret, "secret", os.Getenv("FBWHS_SECRET"), "Owner secret")
+	fs.StringVar(&statePath, "state", defaultStatePath(), "State file")
+}
+
+// remember fills in the source and secret from the state file when they are
+// not given, and remembers them for the next run.
+func remember() {
+	st := loadState(statePath)
+	if src == "" {
+		src = st.Src
+	}
+	if src == "" {
+		eventID := ksuid.New().String()
+		src = fmt.Sprintf("https://fbwhs.herokuapp.com/webhook/%s", eventID)
+	}
+	if secret == "" && src == st.Src {
+		secret = st.Secret
+	}
+	if err := saveState(statePath, state{Src: src, Secret: secret}); err != nil {
+		fmt.Printf("Unable to save state, error: %s\n", err.Error())
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replay(os.Args[2:])
+			return
+		case "alias":
+			alias(os.Args[2:])
+			return
+		case "record":
+			record(os.Args[2:])
+			return
+		case "play":
+			play(os.Args[2:])
+			return
+		case "export":
+			export(os.Args[2:])
+			return
+		}
+	}
+
+	flag.Parse()
+	if configPath != "" {
+		runConfig(configPath)
+		return
+	}
+	args := flag.Args()
+	if len(args) != 1 {
+		fmt.Println("Error: <dest> is required")
+		fmt.Println()
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+
+	transforms, err := parseTransforms(rewrite, templatePath)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	headers, err := headerPolicy(setHeader, allowHeader, denyHeader, renameHeader)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	headers.OmitForwarded = !forwarded
+	sourceHeader, err := parseHeaders(srcHeader)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	remember()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf(`Forwarding SSE from "%s" to "%s"`, src, args[0])
+	fmt.Printf("\n")
+	fmt.Printf("Usage:\n")
+	fmt.Printf("curl -X POST -d 'test=123' \"%s\"\n", src)
+	r := &route{
+		src:        src,
+		dest:       args[0],
+		group:      group,
+		filter:     filter,
+		secret:     secret,
+		encrypt:    encrypt,
+		buffer:     buffer,
+		bufferFile: bufferFile,
+		transforms: transforms,
+		headers:    headers,
+		srcHeader:  sourceHeader,
+		proxy:      proxy,
+		tls:        client.TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+		insecure:   insecure,
+		verbose:    true,
+	}
+	var ui *tui
+	if interactive {
+		ui = newTUI(fixtures)
+		r.tui = ui
+	}
+	done, err := r.start(ctx)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	r.printStatus(ctx)
+	wait(ctx, ui, done)
+}
+
+// wait returns once the routes are done, or the terminal UI, if any, quits.
+func wait(ctx context.Context, ui *tui, running ...<-chan struct{}) {
+	if ui != nil {
+		if err := ui.run(ctx); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	for _, done := range running {
+		<-done
+	}
+}
+
+// runConfig forwards every route of a config file until interrupted.
+func runConfig(path string) {
+	routes, err := loadConfig(path)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var ui *tui
+	if interactive {
+		ui = newTUI(fixtures)
+	}
+	var running []<-chan struct{}
+	for _, r := range routes {
+		r.tui = ui
+	}
+	// Routes sharing a relay and subscription options share a connection.
+	for _, routes := range streams(routes) {
+		done, err := startStream(ctx, routes)
+		if err != nil {
+			routes[0].printf("Error: %s\n", err.Error())
+			continue
+		}
+		running = append(running, done)
+	}
+	if len(running) == 0 {
+		os.Exit(1)
+	}
+	wait(ctx, ui, running...)
+}
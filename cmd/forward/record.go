@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"fbwhs/client"
+)
+
+func record(arguments []string) {
+	var out string
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	fs.StringVar(&src, "src", "", "Webhook SSE source")
+	fs.StringVar(&src, "s", "", "Webhook SSE source")
+	fs.StringVar(&group, "group", "", "Subscriber group")
+	fs.StringVar(&group, "g", "", "Subscriber group")
+	fs.Var(&filter, "filter", "Server side filter")
+	fs.BoolVar(&encrypt, "encrypt", false, "Seal deliveries")
+	fs.StringVar(&out, "out", "", "Session file")
+	stateFlags(fs)
+	fs.Parse(arguments)
+
+	lastSource()
+	if src == "" || out == "" || fs.NArg() != 0 {
+		fmt.Println("Error: -src and -out are required")
+		fmt.Println()
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r := &route{src: src, group: group, filter: filter, secret: secret, encrypt: encrypt}
+	deliveries, err := r.subscribe(ctx)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Recording SSE from \"%s\" to \"%s\"\n", src, out)
+	recorder := client.NewRecorder(f)
+	n := 0
+	for d := range deliveries {
+		if err := recorder.Record(d); err != nil {
+			fmt.Printf("Unable to record %s, error: %s\n", d.ID, err.Error())
+			continue
+		}
+		n++
+		fmt.Printf("Recorded %s\n", d.ID)
+	}
+	fmt.Printf("Recorded %d event(s)\n", n)
+}
+
+func play(arguments []string) {
+	var timing bool
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	fs.BoolVar(&timing, "timing", false, "Keep the original timing")
+	fs.Parse(arguments)
+
+	args := fs.Args()
+	if len(args) != 2 {
+		fmt.Println("Error: <session> and <dest> are required")
+		fmt.Println()
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	session, err := client.ReadSession(f)
+	f.Close()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	dest, err := client.NewDestination(args[1], nil)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Playing %d event(s) from \"%s\" to \"%s\"\n", len(session), args[0], args[1])
+	r := &route{dest: args[1]}
+	p := &client.Player{Destination: dest, Timing: timing, OnResult: r.result}
+	if err := p.Play(ctx, session); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
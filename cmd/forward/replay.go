@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"fbwhs/client"
+)
+
+func replay(arguments []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&src, "src", "", "Webhook SSE source")
+	fs.StringVar(&src, "s", "", "Webhook SSE source")
+	stateFlags(fs)
+	fs.Parse(arguments)
+
+	args := fs.Args()
+	lastSource()
+	if src == "" || len(args) < 1 || len(args) > 2 {
+		fmt.Println("Error: -src and <id> are required")
+		fmt.Println()
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	hc := &http.Client{Timeout: client.DefaultForwardTimeout}
+	if secret != "" {
+		hc = client.WithSecret(hc, secret)
+	}
+	var err error
+	if len(args) == 2 {
+		err = replayLocal(ctx, hc, src, args[0], args[1])
+	} else if err = client.Replay(ctx, hc, src, args[0]); err == nil {
+		fmt.Println("Delivery replayed")
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+// replayLocal fetches the delivery from the server and sends it straight to
+// dest, bypassing the SSE stream. hc, which may carry the owner secret, is
+// only used for the server.
+func replayLocal(ctx context.Context, hc *http.Client, src, id, dest string) error {
+	d, err := client.FetchDelivery(ctx, hc, src, id)
+	if err != nil {
+		return err
+	}
+	destination, err := client.NewDestination(dest, &http.Client{Timeout: client.DefaultForwardTimeout})
+	if err != nil {
+		return err
+	}
+	if _, err := destination.Deliver(ctx, d.AsReplay()); err != nil {
+		return err
+	}
+	fmt.Printf("Delivery %s replayed to \"%s\"\n", d.ID, dest)
+	return nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"fbwhs/client"
+)
+
+// queueLength is how many deliveries a route queues while forwarding, so
+// that a slow destination does not hold up the routes sharing its stream.
+const queueLength = 100
+
+// route forwards the deliveries of a webhook to a destination.
+type route struct {
+	name       string
+	src        string
+	dest       string
+	group      string
+	secret     string
+	encrypt    bool
+	objects    []string
+	fields     []string
+	filter     []string
+	headers    client.HeaderPolicy
+	retries    int
+	retryDelay time.Duration
+	buffer     bool
+	bufferFile string
+	transforms []client.Transform
+	srcHeader  http.Header
+	proxy      string
+	// tls applies to both the source and the destination, insecure only to
+	// the destination.
+	tls      client.TLSOptions
+	insecure bool
+	// verbose prints whole deliveries rather than a status line.
+	verbose bool
+	// tui, if set, shows deliveries and messages instead of printing them.
+	tui *tui
+}
+
+// groupURL adds the subscriber group to the query of src.
+func groupURL(src, group string) (string, error) {
+	if group == "" {
+		return src, nil
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("group", group)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (r *route) printf(format string, args ...interface{}) {
+	if r.tui != nil {
+		r.tui.logf(format, args...)
+		return
+	}
+	if r.name != "" {
+		format = "[" + r.name + "] " + format
+	}
+	fmt.Printf(format, args...)
+}
+
+// start subscribes to the source and forwards its deliveries until ctx is
+// done, at which point the returned channel is closed.
+func (r *route) start(ctx context.Context) (<-chan struct{}, error) {
+	dest, err := r.destination(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := r.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.run(ctx, dest, deliveries), nil
+}
+
+// destination builds the destination, wrapped in the retries and buffer
+// of the route.
+func (r *route) destination(ctx context.Context) (client.Destination, error) {
+	destTLS := r.tls
+	destTLS.Insecure = r.insecure
+	hc, err := client.NewHTTPClient(destTLS, "", client.DefaultForwardTimeout)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := client.NewDestination(r.dest, hc)
+	if err != nil {
+		return nil, err
+	}
+	policy := r.headers
+	policy.Source = r.src
+	switch d := dest.(type) {
+	case *client.HTTPDestination:
+		d.Headers = &policy
+	case *client.ExecDestination:
+		d.Headers = &policy
+	}
+	if r.retries > 0 {
+		dest = &client.RetryDestination{Destination: dest, Retries: r.retries, Delay: r.retryDelay}
+	}
+	if r.buffer {
+		if dest, err = r.buffered(ctx, dest); err != nil {
+			return nil, err
+		}
+	}
+	return dest, nil
+}
+
+// run forwards deliveries to dest one at a time, in the order they arrive,
+// until the channel is closed.
+func (r *route) run(ctx context.Context, dest client.Destination, deliveries <-chan client.Delivery) <-chan struct{} {
+	done := make(chan struct{})
+	queue := make(chan client.Delivery, queueLength)
+	go func() {
+		defer close(queue)
+		for d := range deliveries {
+			if r.matches(d) {
+				queue <- d
+			} else {
+				r.printf("%s filtered out\n", d.ID)
+			}
+		}
+	}()
+	go func() {
+		defer close(done)
+		for d := range queue {
+			r.forward(ctx, d, dest)
+		}
+	}()
+	return done
+}
+
+// subscribe claims the source if there is a secret, and streams its
+// deliveries to the group, sealed to a new key if encrypting.
+func (r *route) subscribe(ctx context.Context) (<-chan client.Delivery, error) {
+	return subscribe(ctx, []*route{r})
+}
+
+// subscribe streams the deliveries of the sources of routes over one
+// connection, with the subscription options of the first route. Every
+// route with a secret claims its source first.
+func subscribe(ctx context.Context, routes []*route) (<-chan client.Delivery, error) {
+	r := routes[0]
+	hc, err := client.NewHTTPClient(r.tls, r.proxy, 0)
+	if err != nil {
+		return nil, err
+	}
+	header := r.srcHeader.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	srcs := make([]string, len(routes))
+	for i, r := range routes {
+		srcs[i] = r.src
+		if r.secret == "" {
+			continue
+		}
+		claimClient := *hc
+		claimClient.Timeout = client.DefaultForwardTimeout
+		claimed, err := client.Claim(ctx, &claimClient, r.src, r.secret)
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			r.printf("Claimed \"%s\"\n", r.src)
+		}
+		if !contains(header.Values(client.SecretHeader), r.secret) {
+			header.Add(client.SecretHeader, r.secret)
+		}
+	}
+	sub := r.src
+	if len(routes) > 1 {
+		if sub, err = client.StreamURL(srcs...); err != nil {
+			return nil, err
+		}
+	}
+	if sub, err = groupURL(sub, r.group); err != nil {
+		return nil, err
+	}
+	var key *ecdh.PrivateKey
+	if r.encrypt {
+		if key, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+
+	return client.Subscribe(ctx, sub, &client.Options{
+		Client: hc,
+		Header: header,
+		Key:    key,
+		Filter: r.filter,
+		OnError: func(err error) {
+			r.printf("%s, reconnecting\n", err.Error())
+		},
+	})
+}
+
+// buffered holds deliveries while the destination is down, on disk if a
+// buffer file is set, and flushes what a previous run left in it.
+func (r *route) buffered(ctx context.Context, dest client.Destination) (client.Destination, error) {
+	var buf client.Buffer
+	if r.bufferFile != "" {
+		fb, err := client.OpenFileBuffer(r.bufferFile)
+		if err != nil {
+			return nil, err
+		}
+		if n := fb.Len(); n > 0 {
+			r.printf("%d event(s) held by a previous run\n", n)
+		}
+		buf = fb
+	}
+	b := client.NewBufferedDestination(dest, buf)
+	b.OnHold = func(d client.Delivery, held int) {
+		r.printf("Destination unavailable, holding event %s (%d held)\n", d.ID, held)
+	}
+	b.OnFlush = r.result
+	b.OnFlushed = func(flushed int) {
+		r.printf("Destination is back, flushed %d held event(s)\n", flushed)
+	}
+	b.OnFlushError = func(err error) {
+		r.printf("Unable to update the buffer, flushing paused, error: %s\n", err.Error())
+	}
+	b.Flush(ctx)
+	return b, nil
+}
+
+// matches applies the object and field filters to Facebook notifications.
+// Messaging events count as the "messages" field.
+func (r *route) matches(d client.Delivery) bool {
+	if len(r.objects) == 0 && len(r.fields) == 0 {
+		return true
+	}
+	n, err := d.Notification()
+	if err != nil {
+		return false
+	}
+	if len(r.objects) > 0 && !contains(r.objects, n.Object) {
+		return false
+	}
+	if len(r.fields) == 0 {
+		return true
+	}
+	for _, e := range n.Entry {
+		if len(e.Messaging) > 0 && contains(r.fields, "messages") {
+			return true
+		}
+		for _, c := range e.Changes {
+			if contains(r.fields, c.Field) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *route) forward(ctx context.Context, d client.Delivery, dest client.Destination) {
+	deliveries, err := client.Apply(d, r.transforms...)
+	if err != nil {
+		r.printf("Unable to transform event %s, error: %s\n", d.ID, err.Error())
+		return
+	}
+	for _, d := range deliveries {
+		if r.tui != nil {
+			r.tui.add(r, dest, d, false)
+		} else if r.verbose {
+			r.printf("Forwarding event %s:\n", d.ID)
+			fmt.Println(d.Webhook())
+		}
+
+		res, err := dest.Deliver(ctx, d)
+		if err == client.ErrHeld {
+			if r.tui != nil {
+				r.tui.result(d, nil, err)
+			}
+			continue
+		}
+		r.result(d, res, err)
+	}
+}
+
+func (r *route) result(d client.Delivery, res *client.Result, err error) {
+	if r.tui != nil {
+		r.tui.result(d, res, err)
+		return
+	}
+	switch err.(type) {
+	case nil:
+		if !r.verbose {
+			r.printf("%s %s in %s\n", d.ID, res.Status(), res.Duration.Round(time.Millisecond))
+		}
+	case *client.StatusError, *client.ExitError:
+		r.printf("Error encountered when forwarding %s: %s\n", d.ID, err.Error())
+	default:
+		r.printf("Failed to forward event %s, error: %s\n", d.ID, err.Error())
+	}
+}
+
+// parseTransforms returns the rewrite rules followed by the template in the
+// file at templatePath, if any.
+func parseTransforms(rules []string, templatePath string) ([]client.Transform, error) {
+	var transforms []client.Transform
+	if len(rules) > 0 {
+		rw, err := client.ParseRewrite(rules...)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, rw)
+	}
+	if templatePath != "" {
+		b, err := os.ReadFile(templatePath)
+		if err != nil {
+			return nil, err
+		}
+		t, err := client.ParseTemplate(string(b))
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
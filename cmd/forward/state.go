@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// state is remembered between runs, so that the webhook URL registered with
+// Facebook keeps working.
+type state struct {
+	Src    string `json:"src"`
+	Secret string `json:"secret,omitempty"`
+}
+
+func defaultStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".fbwhs.json"
+	}
+	return filepath.Join(dir, "fbwhs", "forward.json")
+}
+
+func loadState(path string) state {
+	var s state
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return s
+	}
+	json.Unmarshal(b, &s)
+	return s
+}
+
+func saveState(path string, s state) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// lastSource fills in the source from the state file when none is given, and
+// the secret when the source is the remembered one.
+func lastSource() {
+	st := loadState(statePath)
+	if src == "" {
+		src = st.Src
+	}
+	if secret == "" && src == st.Src {
+		secret = st.Secret
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLastSource(t *testing.T) {
+	statePath = filepath.Join(t.TempDir(), "forward.json")
+	if err := saveState(statePath, state{Src: "https://relay/webhook/team", Secret: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { src, secret = "", "" }()
+
+	tests := []struct {
+		src, wantSrc, wantSecret string
+	}{
+		{"", "https://relay/webhook/team", "s3cret"},
+		{"https://relay/webhook/team", "https://relay/webhook/team", "s3cret"},
+		{"https://relay/webhook/other", "https://relay/webhook/other", ""},
+	}
+	for _, test := range tests {
+		src, secret = test.src, ""
+		lastSource()
+		if src != test.wantSrc || secret != test.wantSecret {
+			t.Errorf("lastSource with %q: got %q and %q", test.src, src, secret)
+		}
+	}
+}
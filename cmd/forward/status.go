@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"fbwhs/client"
+)
+
+// printStatus prints what the relay knows about the source, e.g. when the
+// provider last verified it. Relays without a status endpoint print nothing.
+func (r *route) printStatus(ctx context.Context) {
+	hc, err := client.NewHTTPClient(r.tls, r.proxy, client.DefaultForwardTimeout)
+	if err != nil {
+		return
+	}
+	if r.secret != "" {
+		hc = client.WithSecret(hc, r.secret)
+	}
+	st, err := client.FetchStatus(ctx, hc, r.src)
+	if err != nil {
+		return
+	}
+	r.printf("%s\n", statusLine(st, time.Now()))
+}
+
+// statusLine summarizes a status, e.g. "Verified 3 days ago; last delivery
+// 2 minutes ago, 12 in total; 1 subscriber".
+func statusLine(st client.Status, now time.Time) string {
+	var parts []string
+	switch v := st.Verification; {
+	case v == nil:
+		parts = append(parts, "Not verified yet")
+	case v.OK:
+		parts = append(parts, "Verified "+ago(v.At, now))
+	default:
+		parts = append(parts, "Verification failed "+ago(v.At, now))
+	}
+	if st.LastDelivery == nil {
+		parts = append(parts, "no deliveries yet")
+	} else {
+		parts = append(parts, fmt.Sprintf("last delivery %s, %d in total", ago(*st.LastDelivery, now), st.Deliveries))
+	}
+	parts = append(parts, plural(st.Subscribers, "subscriber"))
+	if st.Queued > 0 {
+		parts = append(parts, fmt.Sprintf("%d queued for a retry", st.Queued))
+	}
+	if len(st.Errors) > 0 {
+		kinds := make([]string, 0, len(st.Errors))
+		for kind := range st.Errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for i, kind := range kinds {
+			kinds[i] = fmt.Sprintf("%d %s", st.Errors[kind], strings.Replace(kind, "_", " ", -1))
+		}
+		parts = append(parts, "errors: "+strings.Join(kinds, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func ago(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute") + " ago"
+	case d < 48*time.Hour:
+		return plural(int(d/time.Hour), "hour") + " ago"
+	default:
+		return plural(int(d/(24*time.Hour)), "day") + " ago"
+	}
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
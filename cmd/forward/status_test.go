@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"fbwhs/client"
+)
+
+func TestStatusLine(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	tests := []struct {
+		status client.Status
+		want   string
+	}{
+		{
+			client.Status{},
+			"Not verified yet; no deliveries yet; 0 subscribers",
+		},
+		{
+			client.Status{
+				Verification: &client.Verification{OK: true, At: *at(3 * 24 * time.Hour)},
+				LastDelivery: at(2 * time.Minute),
+				Deliveries:   12,
+				Subscribers:  1,
+			},
+			"Verified 3 days ago; last delivery 2 minutes ago, 12 in total; 1 subscriber",
+		},
+		{
+			client.Status{
+				Verification: &client.Verification{At: *at(time.Hour)},
+				LastDelivery: at(30 * time.Second),
+				Deliveries:   1,
+				Subscribers:  2,
+				Queued:       3,
+				Errors:       map[string]int64{"push": 1, "no_subscriber": 4},
+			},
+			"Verification failed 1 hour ago; last delivery just now, 1 in total; 2 subscribers; 3 queued for a retry; errors: 4 no subscriber, 1 push",
+		},
+	}
+	for _, test := range tests {
+		if got := statusLine(test.status, now); got != test.want {
+			t.Errorf("Expected %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestAgo(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "just now"},
+		{59 * time.Second, "just now"},
+		{time.Minute, "1 minute ago"},
+		{59 * time.Minute, "59 minutes ago"},
+		{time.Hour, "1 hour ago"},
+		{47 * time.Hour, "47 hours ago"},
+		{48 * time.Hour, "2 days ago"},
+	}
+	for _, test := range tests {
+		if got := ago(now.Add(-test.d), now); got != test.want {
+			t.Errorf("ago(%s): expected %q, got %q", test.d, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"fbwhs/client"
+)
+
+// streamKey tells apart the routes that cannot share a connection: other
+// relays, or other subscription options.
+type streamKey struct {
+	relay, group, filter, proxy, header string
+	encrypt                             bool
+	tls                                 client.TLSOptions
+}
+
+// streams groups routes by the connection they can share, in order.
+func streams(routes []*route) [][]*route {
+	var groups [][]*route
+	index := make(map[streamKey]int)
+	for _, r := range routes {
+		relay, _, err := splitSource(r.src)
+		if err != nil {
+			groups = append(groups, []*route{r})
+			continue
+		}
+		k := streamKey{
+			relay:   relay,
+			group:   r.group,
+			filter:  strings.Join(r.filter, "\n"),
+			proxy:   r.proxy,
+			header:  fmt.Sprint(r.srcHeader),
+			encrypt: r.encrypt,
+			tls:     r.tls,
+		}
+		if i, ok := index[k]; ok {
+			groups[i] = append(groups[i], r)
+			continue
+		}
+		index[k] = len(groups)
+		groups = append(groups, []*route{r})
+	}
+	return groups
+}
+
+// splitSource splits a webhook address into the relay serving it and the
+// name of the webhook.
+func splitSource(src string) (string, string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", "", err
+	}
+	return u.Scheme + "://" + u.Host + path.Dir(u.Path), path.Base(u.Path), nil
+}
+
+// startStream subscribes to the sources of routes over one connection and
+// dispatches every delivery to the routes of its webhook, until ctx is done.
+// Routes whose destination is invalid are left out.
+func startStream(ctx context.Context, routes []*route) (<-chan struct{}, error) {
+	if len(routes) == 1 {
+		done, err := routes[0].start(ctx)
+		if err == nil {
+			routes[0].started(ctx)
+		}
+		return done, err
+	}
+	var started []*route
+	var dests []client.Destination
+	for _, r := range routes {
+		dest, err := r.destination(ctx)
+		if err != nil {
+			r.printf("Error: %s\n", err.Error())
+			continue
+		}
+		started = append(started, r)
+		dests = append(dests, dest)
+	}
+	if len(started) == 0 {
+		return nil, fmt.Errorf("No valid destination")
+	}
+	deliveries, err := subscribe(ctx, started)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := dispatch(deliveries, started)
+	running := make([]<-chan struct{}, len(started))
+	for i, r := range started {
+		running[i] = r.run(ctx, dests[i], inputs[i])
+		r.started(ctx)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, r := range running {
+			<-r
+		}
+	}()
+	return done, nil
+}
+
+// dispatch returns the deliveries of every route, in the order of routes,
+// sending each delivery of a stream to the routes of its webhook. They are
+// closed once deliveries is.
+func dispatch(deliveries <-chan client.Delivery, routes []*route) []<-chan client.Delivery {
+	inputs := make(map[string][]chan client.Delivery)
+	outputs := make([]<-chan client.Delivery, len(routes))
+	for i, r := range routes {
+		_, name, _ := splitSource(r.src)
+		ch := make(chan client.Delivery)
+		inputs[name] = append(inputs[name], ch)
+		outputs[i] = ch
+	}
+	go func() {
+		for d := range deliveries {
+			for _, ch := range inputs[d.WebhookID] {
+				ch <- d
+			}
+		}
+		for _, chs := range inputs {
+			for _, ch := range chs {
+				close(ch)
+			}
+		}
+	}()
+	return outputs
+}
+
+func (r *route) started(ctx context.Context) {
+	r.printf("Forwarding SSE from \"%s\" to \"%s\"\n", r.src, r.dest)
+	go r.printStatus(ctx)
+}
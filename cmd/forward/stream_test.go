@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"fbwhs/client"
+)
+
+func TestStreams(t *testing.T) {
+	routes := []*route{
+		{name: "a", src: "https://relay/webhook/a"},
+		{name: "b", src: "https://relay/webhook/b"},
+		{name: "group", src: "https://relay/webhook/c", group: "alice"},
+		{name: "filter", src: "https://relay/webhook/d", filter: []string{`$.object == "page"`}},
+		{name: "encrypt", src: "https://relay/webhook/e", encrypt: true},
+		{name: "other relay", src: "https://other/webhook/a"},
+		{name: "a again", src: "https://relay/webhook/a"},
+	}
+	var got [][]string
+	for _, group := range streams(routes) {
+		var names []string
+		for _, r := range group {
+			names = append(names, r.name)
+		}
+		got = append(got, names)
+	}
+	want := [][]string{{"a", "b", "a again"}, {"group"}, {"filter"}, {"encrypt"}, {"other relay"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected streams %v, got %v", want, got)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	routes := []*route{
+		{src: "https://relay/webhook/a"},
+		{src: "https://relay/webhook/b"},
+		{src: "https://relay/webhook/a"},
+	}
+	deliveries := make(chan client.Delivery, 3)
+	deliveries <- client.Delivery{ID: "1", WebhookID: "b"}
+	deliveries <- client.Delivery{ID: "2", WebhookID: "a"}
+	deliveries <- client.Delivery{ID: "3", WebhookID: "unknown"}
+	close(deliveries)
+
+	inputs := dispatch(deliveries, routes)
+	received := make([]string, len(routes))
+	done := make(chan int)
+	for i, ch := range inputs {
+		go func(i int, ch <-chan client.Delivery) {
+			for d := range ch {
+				received[i] += d.ID
+			}
+			done <- i
+		}(i, ch)
+	}
+	for range inputs {
+		<-done
+	}
+	if received[0] != "2" || received[1] != "1" || received[2] != "2" {
+		t.Errorf("Deliveries should go to the routes of their webhook, got %v", received)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func makeRaw() (func(), error) {
+	return nil, errors.New("The terminal UI is not supported on Windows")
+}
+
+func terminalSize() (int, int) {
+	return 24, 80
+}
+
+func notifyResize(c chan<- os.Signal) {}
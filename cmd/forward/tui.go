@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fbwhs/client"
+)
+
+const tuiHelp = "↑/↓ select  PgUp/PgDn scroll  r replay  c copy as curl  s save fixture  q quit"
+
+type (
+	// tui shows deliveries in a scrolling list, with the headers and pretty
+	// printed body of the selected one below.
+	tui struct {
+		sync.Mutex
+		entries  []*tuiEntry
+		selected int
+		scroll   int
+		message  string
+		fixtures string
+		redraw   chan struct{}
+	}
+
+	tuiEntry struct {
+		route    *route
+		dest     client.Destination
+		d        client.Delivery
+		received time.Time
+		status   string
+		latency  time.Duration
+		replay   bool
+	}
+)
+
+func newTUI(fixtures string) *tui {
+	return &tui{fixtures: fixtures, redraw: make(chan struct{}, 1)}
+}
+
+func (t *tui) changed() {
+	select {
+	case t.redraw <- struct{}{}:
+	default:
+	}
+}
+
+func (t *tui) logf(format string, args ...interface{}) {
+	t.Lock()
+	t.message = strings.TrimSpace(fmt.Sprintf(format, args...))
+	t.Unlock()
+	t.changed()
+}
+
+// add lists a delivery that is being forwarded and returns its entry.
+func (t *tui) add(r *route, dest client.Destination, d client.Delivery, replay bool) *tuiEntry {
+	e := &tuiEntry{route: r, dest: dest, d: d, received: time.Now(), status: "…", replay: replay}
+	t.Lock()
+	follow := t.selected == len(t.entries)-1
+	t.entries = append(t.entries, e)
+	if follow {
+		t.selected = len(t.entries) - 1
+		t.scroll = 0
+	}
+	t.Unlock()
+	t.changed()
+	return e
+}
+
+// result updates the latest entry of a delivery.
+func (t *tui) result(d client.Delivery, res *client.Result, err error) {
+	t.Lock()
+	var e *tuiEntry
+	for i := len(t.entries) - 1; i >= 0 && e == nil; i-- {
+		if t.entries[i].d.ID == d.ID {
+			e = t.entries[i]
+		}
+	}
+	switch {
+	case e == nil:
+	case err == client.ErrHeld:
+		e.status = "held"
+	case res != nil:
+		e.status = res.Status()
+		e.latency = res.Duration
+	case err != nil:
+		e.status = "error"
+		t.message = fmt.Sprintf("%s: %s", d.ID, err.Error())
+	}
+	t.Unlock()
+	t.changed()
+}
+
+// run draws the UI and handles keys until q is pressed or ctx is done.
+func (t *tui) run(ctx context.Context) error {
+	restore, err := makeRaw()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[H\x1b[2J")
+		restore()
+	}()
+	fmt.Print("\x1b[?25l\x1b[2J")
+
+	keys := make(chan string)
+	go readKeys(keys)
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	rows, cols := terminalSize()
+
+	for {
+		t.draw(rows, cols)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-resized:
+			rows, cols = terminalSize()
+		case <-t.redraw:
+		case key, ok := <-keys:
+			if !ok || key == "q" || key == "\x03" {
+				return nil
+			}
+			t.handle(ctx, key, rows)
+		}
+	}
+}
+
+func (t *tui) handle(ctx context.Context, key string, rows int) {
+	t.Lock()
+	var e *tuiEntry
+	if t.selected >= 0 && t.selected < len(t.entries) {
+		e = t.entries[t.selected]
+	}
+	switch key {
+	case "k", "\x1b[A":
+		if t.selected > 0 {
+			t.selected--
+			t.scroll = 0
+		}
+	case "j", "\x1b[B":
+		if t.selected < len(t.entries)-1 {
+			t.selected++
+			t.scroll = 0
+		}
+	case "\x1b[5~":
+		if t.scroll -= rows / 2; t.scroll < 0 {
+			t.scroll = 0
+		}
+	case "\x1b[6~":
+		t.scroll += rows / 2
+	}
+	t.Unlock()
+	if e == nil {
+		t.changed()
+		return
+	}
+
+	switch key {
+	case "r":
+		d := e.d.AsReplay()
+		replay := t.add(e.route, e.dest, d, true)
+		go func() {
+			res, err := replay.dest.Deliver(ctx, d)
+			t.result(d, res, err)
+		}()
+	case "c":
+		// OSC 52 asks the terminal to set the clipboard.
+		curl := e.d.Curl(e.route.dest)
+		fmt.Printf("\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(curl)))
+		t.logf("Copied %s as curl", e.d.ID)
+	case "s":
+		path, err := t.save(e.d)
+		if err != nil {
+			t.logf("Unable to save %s, error: %s", e.d.ID, err.Error())
+		} else {
+			t.logf("Saved %s to %s", e.d.ID, path)
+		}
+	}
+	t.changed()
+}
+
+// save writes the delivery as a JSON fixture.
+func (t *tui) save(d client.Delivery) (string, error) {
+	if err := os.MkdirAll(t.fixtures, 0755); err != nil {
+		return "", err
+	}
+	b, err := d.Fixture()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(t.fixtures, d.ID+".json")
+	return path, os.WriteFile(path, b, 0644)
+}
+
+func (t *tui) draw(rows, cols int) {
+	t.Lock()
+	defer t.Unlock()
+
+	listRows := rows / 3
+	if listRows < 3 {
+		listRows = 3
+	}
+	lines := []string{fmt.Sprintf("\x1b[7m forward  %d deliveries  %s\x1b[0m", len(t.entries), tuiHelp)}
+
+	start := t.selected - listRows + 1
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < start+listRows; i++ {
+		if i >= len(t.entries) {
+			lines = append(lines, "")
+			continue
+		}
+		line := t.entries[i].summary()
+		if i == t.selected {
+			line = "\x1b[7m" + pad(line, cols) + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, strings.Repeat("─", cols))
+
+	detailRows := rows - len(lines) - 1
+	if t.selected < len(t.entries) {
+		detail := t.entries[t.selected].detail()
+		if t.scroll > len(detail)-1 {
+			t.scroll = len(detail) - 1
+		}
+		if t.scroll < 0 {
+			t.scroll = 0
+		}
+		detail = detail[t.scroll:]
+		for i := 0; i < detailRows; i++ {
+			if i < len(detail) {
+				lines = append(lines, detail[i])
+			} else {
+				lines = append(lines, "")
+			}
+		}
+	} else {
+		for i := 0; i < detailRows; i++ {
+			lines = append(lines, "")
+		}
+	}
+	lines = append(lines, sanitize(t.message))
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString(truncate(line, cols))
+		buf.WriteString("\x1b[K")
+	}
+	os.Stdout.Write(buf.Bytes())
+}
+
+func (e *tuiEntry) summary() string {
+	latency := ""
+	if e.latency > 0 {
+		latency = e.latency.Round(time.Millisecond).String()
+	}
+	name := e.route.name
+	if e.replay {
+		name = strings.TrimSpace(name + " replay")
+	}
+	return fmt.Sprintf(" %s  %-6s %8s  %s  %s", e.received.Format("15:04:05"), sanitize(e.status), latency, sanitize(e.d.ID), sanitize(name))
+}
+
+func (e *tuiEntry) detail() []string {
+	var lines []string
+	for _, k := range sortedHeaderKeys(e.d) {
+		lines = append(lines, fmt.Sprintf("\x1b[1m%s:\x1b[0m %s", sanitize(k), sanitize(strings.Join(e.d.Header[k], ", "))))
+	}
+	lines = append(lines, "")
+
+	var body bytes.Buffer
+	if json.Indent(&body, []byte(e.d.Body), "", "  ") != nil {
+		body.Reset()
+		body.WriteString(e.d.Body)
+	}
+	for _, line := range strings.Split(body.String(), "\n") {
+		lines = append(lines, sanitize(line))
+	}
+	return lines
+}
+
+// sanitize escapes the control characters of delivery content, so that
+// senders cannot write escape sequences to the terminal.
+func sanitize(s string) string {
+	var buf strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			buf.WriteByte(' ')
+		case r < ' ' || r >= 0x7f && r < 0xa0:
+			fmt.Fprintf(&buf, "\\x%02x", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+func sortedHeaderKeys(d client.Delivery) []string {
+	keys := make([]string, 0, len(d.Header))
+	for k := range d.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readKeys sends single keys and escape sequences, such as arrows.
+func readKeys(keys chan<- string) {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			close(keys)
+			return
+		}
+		if b != 0x1b {
+			keys <- string(b)
+			continue
+		}
+		seq := []byte{b}
+		for r.Buffered() > 0 {
+			c, _ := r.ReadByte()
+			seq = append(seq, c)
+			if len(seq) > 2 && (c >= 'A' && c <= 'Z' || c == '~') {
+				break
+			}
+		}
+		keys <- string(seq)
+	}
+}
+
+// truncate cuts s to cols visible characters, ignoring escape sequences.
+func truncate(s string, cols int) string {
+	var buf strings.Builder
+	n := 0
+	escape := false
+	for _, r := range s {
+		switch {
+		case r == '\x1b':
+			escape = true
+		case escape:
+			if r >= '@' && r <= '~' && r != '[' {
+				escape = false
+			}
+		case r < ' ':
+			r = ' '
+			fallthrough
+		default:
+			if n >= cols {
+				continue
+			}
+			n++
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+func pad(s string, cols int) string {
+	if n := len([]rune(s)); n < cols {
+		return s + strings.Repeat(" ", cols-n)
+	}
+	return s
+}
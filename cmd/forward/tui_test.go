@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"fbwhs/client"
+)
+
+func TestTUIEscapesDeliveries(t *testing.T) {
+	evil := "x\x1b]52;c;aGk=\x07\x1b[2J\u009b2J"
+	e := &tuiEntry{
+		route:    &route{name: "evil\x1b[31m"},
+		d:        client.Delivery{ID: "1", Header: http.Header{"X-Evil": {evil}}, Body: "not json\n" + evil},
+		received: time.Now(),
+		status:   "200",
+	}
+	lines := append(e.detail(), e.summary())
+	for _, line := range lines {
+		// Only the styling of the header names may be escape sequences.
+		line = strings.NewReplacer("\x1b[1m", "", "\x1b[0m", "").Replace(line)
+		if strings.ContainsAny(line, "\x1b\x07\u009b") {
+			t.Errorf("Control characters should be escaped, got %q", line)
+		}
+	}
+	if got := lines[len(lines)-2]; got != `x\x1b]52;c;aGk=\x07\x1b[2J\x9b2J` {
+		t.Errorf("Unexpected body line %q", got)
+	}
+}
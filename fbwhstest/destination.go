@@ -0,0 +1,130 @@
+package fbwhstest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// Request is a request received by a Destination.
+	Request struct {
+		Method string
+		Path   string
+		Header http.Header
+		Body   string
+	}
+
+	// Destination is a local webhook handler that records every request.
+	Destination struct {
+		URL string
+
+		mu       sync.Mutex
+		status   int
+		requests []Request
+		received chan struct{}
+	}
+)
+
+// NewDestination starts a destination that answers 200 OK until told
+// otherwise, and is shut down when the test finishes.
+func NewDestination(t testing.TB) *Destination {
+	d := &Destination{status: http.StatusOK, received: make(chan struct{})}
+	srv := httptest.NewServer(http.HandlerFunc(d.serveHTTP))
+	t.Cleanup(srv.Close)
+	d.URL = srv.URL
+	return d
+}
+
+// SetStatus changes the status code returned to subsequent requests.
+func (d *Destination) SetStatus(code int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = code
+}
+
+// Requests returns the requests received so far.
+func (d *Destination) Requests() []Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Request(nil), d.requests...)
+}
+
+// Wait waits for at least n requests and fails the test if they do not
+// arrive within Timeout.
+func (d *Destination) Wait(t testing.TB, n int) []Request {
+	t.Helper()
+	timeout := time.After(Timeout)
+	for {
+		d.mu.Lock()
+		requests, received := append([]Request(nil), d.requests...), d.received
+		d.mu.Unlock()
+		if len(requests) >= n {
+			return requests
+		}
+
+		select {
+		case <-received:
+		case <-timeout:
+			t.Fatalf("Expected %d request(s), received %d", n, len(requests))
+			return nil
+		}
+	}
+}
+
+// AssertReceived waits for a request with the given body and fails the test
+// if none arrives within Timeout.
+func (d *Destination) AssertReceived(t testing.TB, body string) Request {
+	t.Helper()
+	timeout := time.After(Timeout)
+	for {
+		d.mu.Lock()
+		requests, received := d.requests, d.received
+		d.mu.Unlock()
+		for _, r := range requests {
+			if r.Body == body {
+				return r
+			}
+		}
+
+		select {
+		case <-received:
+		case <-timeout:
+			t.Fatalf("No request received with body %q, got %d other request(s)", body, len(requests))
+			return Request{}
+		}
+	}
+}
+
+// AssertNotReceived fails the test if a request with the given body has
+// arrived, or arrives within the given duration.
+func (d *Destination) AssertNotReceived(t testing.TB, body string, within time.Duration) {
+	t.Helper()
+	time.Sleep(within)
+	for _, r := range d.Requests() {
+		if r.Body == body {
+			t.Fatalf("Unexpected request with body %q", body)
+		}
+	}
+}
+
+func (d *Destination) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	d.mu.Lock()
+	d.requests = append(d.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header,
+		Body:   string(body),
+	})
+	status := d.status
+	close(d.received)
+	d.received = make(chan struct{})
+	d.mu.Unlock()
+
+	rw.WriteHeader(status)
+}
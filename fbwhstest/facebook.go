@@ -0,0 +1,124 @@
+package fbwhstest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// FacebookSender behaves like Facebook delivering webhooks to a callback URL.
+type FacebookSender struct {
+	URL         string
+	VerifyToken string
+	AppSecret   string
+	Client      *http.Client
+}
+
+// NewFacebookSender sends to callbackURL, using its last path segment as the
+// verify token like the relay expects.
+func NewFacebookSender(callbackURL, appSecret string) *FacebookSender {
+	return &FacebookSender{
+		URL:         callbackURL,
+		VerifyToken: path.Base(callbackURL),
+		AppSecret:   appSecret,
+		Client:      &http.Client{Timeout: Timeout},
+	}
+}
+
+// Verify performs the verification request Facebook sends when a callback
+// URL is registered, and checks that the challenge is echoed back.
+// See https://developers.facebook.com/docs/graph-api/webhooks/getting-started#verification-requests
+func (s *FacebookSender) Verify() error {
+	challenge := ksuid.New().String()
+	query := url.Values{
+		"hub.mode":         {"subscribe"},
+		"hub.challenge":    {challenge},
+		"hub.verify_token": {s.VerifyToken},
+	}
+	resp, err := s.Client.Get(s.URL + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != challenge {
+		return fmt.Errorf("Verification failed, status: %d, body: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// Send POSTs a JSON payload signed with the app secret, like Facebook does.
+func (s *FacebookSender) Send(payload string) error {
+	req, err := http.NewRequest("POST", s.URL, strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	sha1Sig, sha256Sig := Signatures(s.AppSecret, payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "facebookexternalua")
+	req.Header.Set("X-Hub-Signature", sha1Sig)
+	req.Header.Set("X-Hub-Signature-256", sha256Sig)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Send failed, status: %d, body: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// SendJSON encodes v and sends it.
+func (s *FacebookSender) SendJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Send(string(b))
+}
+
+// Signatures returns the X-Hub-Signature and X-Hub-Signature-256 header
+// values Facebook would send for payload.
+func Signatures(appSecret, payload string) (string, string) {
+	mac1 := hmac.New(sha1.New, []byte(appSecret))
+	mac1.Write([]byte(payload))
+	mac256 := hmac.New(sha256.New, []byte(appSecret))
+	mac256.Write([]byte(payload))
+	return "sha1=" + hex.EncodeToString(mac1.Sum(nil)), "sha256=" + hex.EncodeToString(mac256.Sum(nil))
+}
+
+// MessageEvent builds a Messenger text message notification sent by senderID
+// to pageID.
+func MessageEvent(pageID, senderID, text string) string {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	b, _ := json.Marshal(map[string]interface{}{
+		"object": "page",
+		"entry": []interface{}{map[string]interface{}{
+			"id":   pageID,
+			"time": now,
+			"messaging": []interface{}{map[string]interface{}{
+				"sender":    map[string]string{"id": senderID},
+				"recipient": map[string]string{"id": pageID},
+				"timestamp": now,
+				"message":   map[string]string{"mid": "m_" + ksuid.New().String(), "text": text},
+			}},
+		}},
+	})
+	return string(b)
+}
@@ -0,0 +1,109 @@
+// Package fbwhstest runs the relay in-process for end-to-end tests. It
+// provides a fake Facebook sender, a real subscriber and a destination that
+// records what it received:
+//
+//	r := fbwhstest.NewRelay(t)
+//	dest := fbwhstest.NewDestination(t)
+//	r.Subscribe(t, "abc123", dest)
+//	fb := fbwhstest.NewFacebookSender(r.WebhookURL("abc123"), "app-secret")
+//	fb.Verify()
+//	fb.Send(fbwhstest.MessageEvent("page-id", "psid", "hello"))
+//	dest.AssertReceived(t, fbwhstest.MessageEvent("page-id", "psid", "hello"))
+package fbwhstest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"fbwhs/client"
+	"fbwhs/relay"
+)
+
+// Timeout bounds how long assertions wait for deliveries.
+var Timeout = 2 * time.Second
+
+// Relay is a relay served by an httptest.Server.
+type Relay struct {
+	*relay.Relay
+	Server *httptest.Server
+}
+
+// NewRelay starts a relay that is shut down when the test finishes.
+func NewRelay(t testing.TB, opts ...relay.Option) *Relay {
+	r := relay.New(opts...)
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		// Subscribers left connected would keep Close waiting forever
+		srv.CloseClientConnections()
+		srv.Close()
+		r.Close()
+	})
+	return &Relay{Relay: r, Server: srv}
+}
+
+// NewCluster starts n relays joined by HTTP buses.
+func NewCluster(t testing.TB, n int, opts ...relay.Option) []*Relay {
+	servers := make([]*httptest.Server, n)
+	urls := make([]string, n)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		urls[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+
+	relays := make([]*Relay, n)
+	for i, srv := range servers {
+		peers := append(append([]string(nil), urls[:i]...), urls[i+1:]...)
+		bus, err := relay.NewHTTPBus(peers, "cluster-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := relay.New(append(opts, relay.WithBus(bus))...)
+		srv.Config.Handler = r
+		srv.Start()
+		t.Cleanup(func() {
+			srv.CloseClientConnections()
+			srv.Close()
+			r.Close()
+		})
+		relays[i] = &Relay{Relay: r, Server: srv}
+	}
+	return relays
+}
+
+func (r *Relay) WebhookURL(webhookID string) string {
+	return r.Server.URL + "/webhook/" + webhookID
+}
+
+// Subscribe connects a subscriber to the webhook that forwards every delivery
+// to dest, in order, until the test finishes. It returns once the subscriber
+// is connected.
+func (r *Relay) Subscribe(t testing.TB, webhookID string, dest *Destination, opts ...*client.Options) {
+	t.Helper()
+	var o *client.Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deliveries, err := client.Subscribe(ctx, r.WebhookURL(webhookID), o)
+	if err != nil {
+		cancel()
+		t.Fatalf("Unable to subscribe to %s: %s", webhookID, err)
+	}
+
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	destination, _ := client.NewDestination(dest.URL, nil)
+	go func() {
+		defer close(done)
+		for d := range deliveries {
+			destination.Deliver(ctx, d)
+		}
+	}()
+}
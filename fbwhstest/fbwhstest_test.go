@@ -0,0 +1,102 @@
+package fbwhstest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"fbwhs/client"
+	"fbwhs/fbwhstest"
+	"fbwhs/internal"
+)
+
+func TestRoundTrip(t *testing.T) {
+	r := fbwhstest.NewRelay(t)
+	dest := fbwhstest.NewDestination(t)
+	r.Subscribe(t, "abc123", dest)
+	fb := fbwhstest.NewFacebookSender(r.WebhookURL("abc123"), "app-secret")
+
+	if err := fb.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := fbwhstest.MessageEvent("page", "psid", "hello")
+	if err := fb.Send(payload); err != nil {
+		t.Fatal(err)
+	}
+	req := dest.AssertReceived(t, payload)
+	sha1Sig, sha256Sig := fbwhstest.Signatures("app-secret", payload)
+	if req.Header.Get("X-Hub-Signature") != sha1Sig || req.Header.Get("X-Hub-Signature-256") != sha256Sig {
+		t.Errorf("Signatures should be forwarded, got %v", req.Header)
+	}
+}
+
+func TestVerifyWrongToken(t *testing.T) {
+	r := fbwhstest.NewRelay(t)
+	fb := fbwhstest.NewFacebookSender(r.WebhookURL("abc123"), "app-secret")
+	fb.VerifyToken = "foo"
+	if fb.Verify() == nil {
+		t.Errorf("Verification should fail with a wrong verify token")
+	}
+}
+
+func TestSendWithoutSubscriber(t *testing.T) {
+	r := fbwhstest.NewRelay(t)
+	fb := fbwhstest.NewFacebookSender(r.WebhookURL("abc123"), "app-secret")
+	if fb.Send(`{"object":"page"}`) == nil {
+		t.Errorf("Send should fail without subscribers")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	r := fbwhstest.NewRelay(t)
+	dest := fbwhstest.NewDestination(t)
+	dest.SetStatus(http.StatusInternalServerError)
+	r.Subscribe(t, "abc123", dest)
+	fb := fbwhstest.NewFacebookSender(r.WebhookURL("abc123"), "app-secret")
+	fb.Send(`{"object":"page"}`)
+	dest.Wait(t, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deliveries, err := client.Subscribe(ctx, r.WebhookURL("abc123"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.Send(`{"object":"user"}`)
+	d := <-deliveries
+
+	dest.SetStatus(http.StatusOK)
+	if err := client.Replay(context.Background(), http.DefaultClient, r.WebhookURL("abc123"), d.ID); err != nil {
+		t.Fatal(err)
+	}
+	requests := dest.Wait(t, 3)
+	if requests[2].Header.Get(internal.ReplayHeader) != d.ID || requests[2].Body != `{"object":"user"}` {
+		t.Errorf("Replayed delivery should reach the destination, got %+v", requests[2])
+	}
+}
+
+func TestCluster(t *testing.T) {
+	nodes := fbwhstest.NewCluster(t, 3)
+	dest := fbwhstest.NewDestination(t)
+	nodes[2].Subscribe(t, "abc123", dest)
+
+	fb := fbwhstest.NewFacebookSender(nodes[0].WebhookURL("abc123"), "app-secret")
+	if err := fb.Send(`{"object":"page"}`); err != nil {
+		t.Fatal(err)
+	}
+	dest.AssertReceived(t, `{"object":"page"}`)
+
+	fb = fbwhstest.NewFacebookSender(nodes[1].WebhookURL("abc123"), "app-secret")
+	if err := fb.Send(`{"object":"user"}`); err != nil {
+		t.Fatal(err)
+	}
+	dest.AssertReceived(t, `{"object":"user"}`)
+
+	// Only node 2 has subscribers
+	nodes[2].Server.CloseClientConnections()
+	nodes[2].Server.Close()
+	if err := fb.Send(`{"object":"page"}`); err == nil {
+		t.Errorf("Send should fail once the subscriber's node is gone")
+	}
+}
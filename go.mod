@@ -2,9 +2,8 @@ module fbwhs
 
 require (
 	github.com/davidsbond/sse v0.0.0-20180607143305-0252f9c8fba3
-	github.com/r3labs/sse v0.0.0-20181217150409-243b7807c4c4
 	github.com/rs/xid v1.2.1 // indirect
 	github.com/segmentio/ksuid v1.0.2
-	gopkg.in/cenkalti/backoff.v1 v1.1.0 // indirect
+	gopkg.in/ini.v1 v1.46.0
 	gopkg.in/macaron.v1 v1.3.4
 )
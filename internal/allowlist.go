@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type (
+	// Allowlist restricts who can post to webhooks that have an AllowFrom
+	// config. Entries are IPs, CIDR ranges or the name of a range set, e.g.
+	// "facebook" for Facebook's published ranges loaded from a file.
+	Allowlist struct {
+		sets    map[string][]*net.IPNet
+		trusted []*net.IPNet
+		allowed int64
+		denied  int64
+	}
+)
+
+// NewAllowlist honors X-Forwarded-For only on requests coming from trusted
+// proxies.
+func NewAllowlist(sets map[string][]*net.IPNet, trustedProxies []*net.IPNet) *Allowlist {
+	if sets == nil {
+		sets = make(map[string][]*net.IPNet)
+	}
+	return &Allowlist{sets: sets, trusted: trustedProxies}
+}
+
+// ParseCIDRs parses CIDR ranges, where a bare IP stands for itself.
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	ranges := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		n, err := parseCIDR(e)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, n)
+	}
+	return ranges, nil
+}
+
+// LoadCIDRs reads CIDR ranges from a file, one per line. Blank lines and
+// lines starting with # are ignored.
+func LoadCIDRs(path string) ([]*net.IPNet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseCIDRs(entries)
+}
+
+func parseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP or CIDR range: %s", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid IP or CIDR range: %s", s)
+	}
+	return n, nil
+}
+
+// Validate checks that every entry is an IP, a CIDR range or a known range
+// set.
+func (a *Allowlist) Validate(entries []string) error {
+	for _, e := range entries {
+		if _, ok := a.sets[e]; ok {
+			continue
+		}
+		if _, err := parseCIDR(e); err != nil {
+			return fmt.Errorf("Invalid IP, CIDR range or range set: %s", e)
+		}
+	}
+	return nil
+}
+
+// ClientIP returns the address of the sender of r, read from
+// X-Forwarded-For as long as the hops are trusted proxies.
+func (a *Allowlist) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contained(a.trusted, ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if ip = net.ParseIP(hop); ip == nil || !contained(a.trusted, ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// Allow reports whether the sender of r matches one of the entries of a
+// webhook, and logs and counts the decision.
+func (a *Allowlist) Allow(webhookID string, entries []string, r *http.Request) bool {
+	ip := a.ClientIP(r)
+	if ip != nil {
+		for _, e := range entries {
+			ranges, ok := a.sets[e]
+			if !ok {
+				n, err := parseCIDR(e)
+				if err != nil {
+					continue
+				}
+				ranges = []*net.IPNet{n}
+			}
+			if contained(ranges, ip) {
+				atomic.AddInt64(&a.allowed, 1)
+				log.Printf("Allowed %s to post to webhook %s (%s)", ip, webhookID, e)
+				return true
+			}
+		}
+	}
+	atomic.AddInt64(&a.denied, 1)
+	log.Printf("Denied %s to post to webhook %s", ip, webhookID)
+	return false
+}
+
+func (a *Allowlist) Stats() (allowed, denied int64) {
+	return atomic.LoadInt64(&a.allowed), atomic.LoadInt64(&a.denied)
+}
+
+func contained(ranges []*net.IPNet, ip net.IP) bool {
+	for _, n := range ranges {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package internal_test
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fbwhs/internal"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, _ := internal.ParseCIDRs([]string{"10.0.0.0/8"})
+	a := internal.NewAllowlist(nil, proxies)
+
+	cases := []struct {
+		remote, xff, ip string
+	}{
+		{"1.2.3.4:1234", "", "1.2.3.4"},
+		{"1.2.3.4:1234", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:1234", "5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:1234", "6.6.6.6, 5.6.7.8, 10.0.0.2", "5.6.7.8"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "garbage", "<nil>"},
+	}
+	for _, c := range cases {
+		r, _ := http.NewRequest("POST", "/webhook/abc123", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if ip := a.ClientIP(r).String(); ip != c.ip {
+			t.Errorf("%s via %q: expected %s, got %s", c.remote, c.xff, c.ip, ip)
+		}
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facebook.txt")
+	os.WriteFile(path, []byte("# Facebook\n31.13.24.0/21\n\n2a03:2880::/32\n"), 0600)
+	facebook, err := internal.LoadCIDRs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := internal.NewAllowlist(map[string][]*net.IPNet{"facebook": facebook}, nil)
+
+	if a.Validate([]string{"facebook", "1.2.3.4", "192.168.0.0/16"}) != nil {
+		t.Errorf("IPs, ranges and range sets should be valid")
+	}
+	if a.Validate([]string{"google"}) == nil {
+		t.Errorf("Unknown range sets should be invalid")
+	}
+
+	entries := []string{"facebook", "1.2.3.4"}
+	for remote, allowed := range map[string]bool{
+		"31.13.24.1:1234":   true,
+		"[2a03:2880::1]:80": true,
+		"1.2.3.4:1234":      true,
+		"1.2.3.5:1234":      false,
+	} {
+		r, _ := http.NewRequest("POST", "/webhook/abc123", nil)
+		r.RemoteAddr = remote
+		if a.Allow("abc123", entries, r) != allowed {
+			t.Errorf("%s: expected allowed to be %v", remote, allowed)
+		}
+	}
+	if allowed, denied := a.Stats(); allowed != 3 || denied != 1 {
+		t.Errorf("Decisions should be counted, got %d allowed, %d denied", allowed, denied)
+	}
+}
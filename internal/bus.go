@@ -0,0 +1,42 @@
+package internal
+
+import "sync"
+
+type (
+	// Bus carries deliveries between the nodes of a cluster. Every node
+	// registers a handler delivering to its local subscribers.
+	Bus interface {
+		// Publish hands d to the handler of every node and returns how many
+		// subscribers it reached.
+		Publish(d Delivery) (int, error)
+		Handle(fn func(d Delivery) int)
+	}
+
+	// MemBus connects the handlers living in the same process.
+	MemBus struct {
+		sync.Mutex
+		handlers []func(d Delivery) int
+	}
+)
+
+func NewMemBus() *MemBus {
+	return &MemBus{}
+}
+
+func (b *MemBus) Handle(fn func(d Delivery) int) {
+	b.Lock()
+	defer b.Unlock()
+	b.handlers = append(b.handlers, fn)
+}
+
+func (b *MemBus) Publish(d Delivery) (int, error) {
+	b.Lock()
+	handlers := b.handlers
+	b.Unlock()
+
+	n := 0
+	for _, fn := range handlers {
+		n += fn(d)
+	}
+	return n, nil
+}
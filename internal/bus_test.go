@@ -0,0 +1,61 @@
+package internal_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fbwhs/internal"
+)
+
+func TestMemBus(t *testing.T) {
+	bus := internal.NewMemBus()
+	b1, b2 := &inMemBroker{}, &inMemBroker{}
+	wh1, wh2 := internal.NewWebhookHandler(b1), internal.NewWebhookHandler(b2)
+	wh1.SetBus(bus)
+	wh2.SetBus(bus)
+	wh2.Subscribe("abc123")
+
+	if err := wh1.Forward("abc123", nil, "a body"); err != nil {
+		t.Fatalf("Forward should reach subscribers of other nodes, got %s", err)
+	}
+	if len(b1.events) != 0 || len(b2.events) != 1 {
+		t.Errorf("Only the subscriber's node should broadcast")
+	}
+
+	d, ok := lastDelivery(wh2, b2, "abc123")
+	if !ok {
+		t.Fatalf("Delivery should be retained by the subscriber's node")
+	}
+	if _, ok := wh1.Delivery("abc123", d.ID); !ok {
+		t.Errorf("Delivery should be retained by every node")
+	}
+
+	if err := wh1.Forward("foo", nil, "a body"); err == nil {
+		t.Errorf("Should fail without subscribers on any node")
+	}
+}
+
+func TestHTTPBusSecret(t *testing.T) {
+	if _, err := internal.NewHTTPBus(nil, ""); err != internal.ErrNoClusterSecret {
+		t.Errorf("Empty secrets should be refused, got %v", err)
+	}
+
+	for _, bus := range []*internal.HTTPBus{{}, mustHTTPBus(t, "cluster-secret")} {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", internal.ClusterPath, strings.NewReader(`{"webhook_id":"abc123"}`))
+		bus.ServeHTTP(rw, req)
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Deliveries without the secret should be refused, got %d", rw.Code)
+		}
+	}
+}
+
+func mustHTTPBus(t *testing.T, secret string) *internal.HTTPBus {
+	bus, err := internal.NewHTTPBus(nil, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bus
+}
@@ -0,0 +1,86 @@
+package internal
+
+import "sync"
+
+type (
+	// WebhookConfig holds the per-webhook settings.
+	WebhookConfig struct {
+		Routes []Route `json:"routes,omitempty"`
+		// Owner is the hash of the secret of a claimed webhook.
+		Owner string `json:"owner,omitempty"`
+		// AliasOf is the webhook an alias points to.
+		AliasOf string `json:"alias_of,omitempty"`
+		// AllowFrom restricts who can post to the webhook, see Allowlist.
+		AllowFrom []string `json:"allow_from,omitempty"`
+		// Targets get the deliveries pushed by the relay.
+		Targets []Target `json:"targets,omitempty"`
+		// Sealed is set once a subscriber with a key subscribes, and stops
+		// the deliveries of the webhook from being retained.
+		Sealed bool `json:"sealed,omitempty"`
+	}
+
+	// ConfigStore keeps the config of every webhook. In cluster mode every
+	// node must share the same store.
+	ConfigStore interface {
+		Get(webhookID string) (WebhookConfig, bool)
+		// Update applies fn to the config of a webhook, starting from the
+		// zero value if it has none, and stores the result unless fn fails.
+		Update(webhookID string, fn func(c *WebhookConfig) error) (WebhookConfig, error)
+		Delete(webhookID string) bool
+	}
+
+	memConfigStore struct {
+		sync.Mutex
+		configs map[string]WebhookConfig
+	}
+)
+
+// forgetConfig drops the config of an idle webhook, except its claim and
+// alias, and whether it is sealed: a reserved name that expired could
+// otherwise be claimed by anyone, and receive the traffic meant for its owner.
+func (wh *WebhookHandler) forgetConfig(webhookID string) bool {
+	c, ok := wh.configStore.Get(webhookID)
+	if !ok {
+		return false
+	}
+	if c.Owner == "" && c.AliasOf == "" {
+		return wh.configStore.Delete(webhookID)
+	}
+	forgot := false
+	wh.configStore.Update(webhookID, func(c *WebhookConfig) error {
+		forgot = len(c.Routes) > 0 || len(c.AllowFrom) > 0 || len(c.Targets) > 0
+		*c = WebhookConfig{Owner: c.Owner, AliasOf: c.AliasOf, Sealed: c.Sealed}
+		return nil
+	})
+	return forgot
+}
+
+func NewMemConfigStore() ConfigStore {
+	return &memConfigStore{configs: make(map[string]WebhookConfig)}
+}
+
+func (s *memConfigStore) Get(webhookID string) (WebhookConfig, bool) {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.configs[webhookID]
+	return c, ok
+}
+
+func (s *memConfigStore) Update(webhookID string, fn func(c *WebhookConfig) error) (WebhookConfig, error) {
+	s.Lock()
+	defer s.Unlock()
+	c := s.configs[webhookID]
+	if err := fn(&c); err != nil {
+		return WebhookConfig{}, err
+	}
+	s.configs[webhookID] = c
+	return c, nil
+}
+
+func (s *memConfigStore) Delete(webhookID string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.configs[webhookID]
+	delete(s.configs, webhookID)
+	return ok
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	DeliveryRetention = 20
+	ReplayHeader      = "X-Fbwhs-Replay"
+)
+
+var ErrDeliveryNotFound = errors.New("Delivery not found")
+
+type (
+	Delivery struct {
+		ID         string      `json:"id"`
+		WebhookID  string      `json:"wid"`
+		Header     http.Header `json:"header"`
+		Body       string      `json:"body"`
+		ReceivedAt time.Time   `json:"received_at"`
+		// Groups are the subscriber groups the delivery was routed to, none
+		// means subscribers without a group.
+		Groups []string `json:"groups,omitempty"`
+	}
+
+	// DeliveryStore retains recent deliveries per webhook so that they can be
+	// inspected and replayed later.
+	DeliveryStore interface {
+		Save(d Delivery) error
+		Get(webhookID, deliveryID string) (Delivery, bool)
+		// Expire drops deliveries received before the given time and returns
+		// how many were dropped.
+		Expire(before time.Time) int
+	}
+
+	memDeliveryStore struct {
+		sync.Mutex
+		limit      int
+		deliveries map[string][]Delivery
+	}
+)
+
+func NewDelivery(webhookID string, header http.Header, body string) Delivery {
+	return Delivery{
+		ID:         ksuid.New().String(),
+		WebhookID:  webhookID,
+		Header:     header,
+		Body:       body,
+		ReceivedAt: time.Now(),
+	}
+}
+
+func (d Delivery) Webhook() Webhook {
+	return Webhook{ID: d.ID, Header: d.Header, Body: d.Body}
+}
+
+// NewMemDeliveryStore keeps the last limit deliveries of every webhook in
+// memory.
+func NewMemDeliveryStore(limit int) DeliveryStore {
+	return &memDeliveryStore{
+		limit:      limit,
+		deliveries: make(map[string][]Delivery),
+	}
+}
+
+func (s *memDeliveryStore) Save(d Delivery) error {
+	s.Lock()
+	defer s.Unlock()
+	ds := append(s.deliveries[d.WebhookID], d)
+	if len(ds) > s.limit {
+		ds = append([]Delivery(nil), ds[len(ds)-s.limit:]...)
+	}
+	s.deliveries[d.WebhookID] = ds
+	return nil
+}
+
+func (s *memDeliveryStore) Get(webhookID, deliveryID string) (Delivery, bool) {
+	s.Lock()
+	defer s.Unlock()
+	for _, d := range s.deliveries[webhookID] {
+		if d.ID == deliveryID {
+			return d, true
+		}
+	}
+	return Delivery{}, false
+}
+
+func (s *memDeliveryStore) Expire(before time.Time) int {
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for wid, ds := range s.deliveries {
+		i := 0
+		for i < len(ds) && ds[i].ReceivedAt.Before(before) {
+			i++
+		}
+		n += i
+		if i == len(ds) {
+			delete(s.deliveries, wid)
+		} else if i > 0 {
+			s.deliveries[wid] = append([]Delivery(nil), ds[i:]...)
+		}
+	}
+	return n
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const SweepInterval = time.Minute
+
+var DefaultExpiration = Expiration{
+	Webhook:    72 * time.Hour,
+	Deliveries: 24 * time.Hour,
+}
+
+type (
+	// Expiration configures how long idle state is kept around. A zero
+	// duration keeps that state forever. A webhook is idle while it has no
+	// subscribers; its per-webhook config never outlives the webhook itself.
+	Expiration struct {
+		Webhook    time.Duration
+		Deliveries time.Duration
+		Config     time.Duration
+	}
+
+	SweepResult struct {
+		Webhooks   []string
+		Configs    []string
+		Deliveries int
+	}
+
+	configRegistration struct {
+		name   string
+		forget func(webhookID string) bool
+	}
+)
+
+func (wh *WebhookHandler) SetExpiration(e Expiration) {
+	wh.Lock()
+	defer wh.Unlock()
+	wh.expiration = e
+}
+
+// RegisterConfig registers per-webhook config to be dropped by the sweeper.
+// forget reports whether the webhook had any config to drop.
+func (wh *WebhookHandler) RegisterConfig(name string, forget func(webhookID string) bool) {
+	wh.Lock()
+	defer wh.Unlock()
+	wh.configs = append(wh.configs, configRegistration{name: name, forget: forget})
+}
+
+// Sweep reclaims idle webhooks, their config and old deliveries as of now.
+func (wh *WebhookHandler) Sweep(now time.Time) SweepResult {
+	var res SweepResult
+	var forget []string
+
+	wh.Lock()
+	exp := wh.expiration
+	configs := wh.configs
+	for wid, w := range wh.subscriptions {
+		if len(w.subscribers) > 0 {
+			continue
+		}
+		idle := now.Sub(w.lastActivity)
+		if exp.Webhook > 0 && idle > exp.Webhook {
+			delete(wh.subscriptions, wid)
+			res.Webhooks = append(res.Webhooks, wid)
+			if !w.configExpired {
+				forget = append(forget, wid)
+			}
+		} else if exp.Config > 0 && idle > exp.Config && !w.configExpired {
+			w.configExpired = true
+			forget = append(forget, wid)
+		}
+	}
+	wh.Unlock()
+
+	for _, wid := range forget {
+		var names []string
+		for _, c := range configs {
+			if c.forget(wid) {
+				names = append(names, c.name)
+			}
+		}
+		if len(names) > 0 {
+			res.Configs = append(res.Configs, wid+" ("+strings.Join(names, ", ")+")")
+		}
+	}
+	if exp.Deliveries > 0 {
+		res.Deliveries = wh.deliveries.Expire(now.Add(-exp.Deliveries))
+	}
+
+	sort.Strings(res.Webhooks)
+	sort.Strings(res.Configs)
+	return res
+}
+
+// StartSweeper sweeps every interval until the returned stop function is
+// called.
+func (wh *WebhookHandler) StartSweeper(interval time.Duration) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				logSweep(wh.Sweep(now))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func logSweep(res SweepResult) {
+	if len(res.Webhooks) == 0 && len(res.Configs) == 0 && res.Deliveries == 0 {
+		return
+	}
+	log.Printf(
+		"Swept %d idle webhook(s) %v, config of %d webhook(s) %v, %d expired deliveries",
+		len(res.Webhooks), res.Webhooks, len(res.Configs), res.Configs, res.Deliveries,
+	)
+}
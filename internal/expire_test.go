@@ -0,0 +1,101 @@
+package internal_test
+
+import (
+	"testing"
+	"time"
+
+	"fbwhs/internal"
+)
+
+func TestSweepIdleWebhook(t *testing.T) {
+	wh := internal.NewWebhookHandler(&inMemBroker{})
+	wh.SetExpiration(internal.Expiration{Webhook: time.Hour})
+	eventID, _ := wh.Subscribe("idle")
+	wh.Unsubscribe(eventID)
+	wh.Subscribe("connected")
+
+	if res := wh.Sweep(time.Now()); len(res.Webhooks) != 0 {
+		t.Errorf("Recently active webhooks should be kept, got %v", res.Webhooks)
+	}
+
+	res := wh.Sweep(time.Now().Add(2 * time.Hour))
+	if len(res.Webhooks) != 1 || res.Webhooks[0] != "idle" {
+		t.Errorf("Only the idle webhook should be swept, got %v", res.Webhooks)
+	}
+	if len(wh.EventIDs("connected")) != 1 {
+		t.Errorf("Connected webhooks should never be swept")
+	}
+	if res := wh.Sweep(time.Now().Add(2 * time.Hour)); len(res.Webhooks) != 0 {
+		t.Errorf("Swept webhooks should be gone, got %v", res.Webhooks)
+	}
+}
+
+func TestSweepConfig(t *testing.T) {
+	wh := internal.NewWebhookHandler(&inMemBroker{})
+	wh.SetExpiration(internal.Expiration{Webhook: 2 * time.Hour, Config: time.Hour})
+	var forgotten []string
+	wh.RegisterConfig("test", func(wid string) bool {
+		forgotten = append(forgotten, wid)
+		return true
+	})
+	wh.Touch("abc123")
+
+	res := wh.Sweep(time.Now().Add(90 * time.Minute))
+	if len(res.Webhooks) != 0 || len(res.Configs) != 1 {
+		t.Errorf("Only the config should be swept, got %+v", res)
+	}
+	res = wh.Sweep(time.Now().Add(3 * time.Hour))
+	if len(res.Webhooks) != 1 || len(res.Configs) != 0 {
+		t.Errorf("Config should not be swept twice, got %+v", res)
+	}
+	if len(forgotten) != 1 || forgotten[0] != "abc123" {
+		t.Errorf("Config should be forgotten once, got %v", forgotten)
+	}
+}
+
+func TestSweepKeepsClaims(t *testing.T) {
+	wh := internal.NewWebhookHandler(&inMemBroker{})
+	wh.SetExpiration(internal.Expiration{Webhook: time.Hour})
+	if _, err := wh.Claim("team-alice", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wh.SetAlias("alice", "team-alice"); err != nil {
+		t.Fatal(err)
+	}
+	setRoutes(t, wh, "team-alice", internal.Route{Page: "123", Group: "alice"})
+
+	wh.Sweep(time.Now().Add(2 * time.Hour))
+	c, _ := wh.Config("team-alice")
+	if c.Owner == "" || len(c.Routes) != 0 {
+		t.Errorf("Only the claim should outlive the webhook, got %+v", c)
+	}
+	if wh.Resolve("alice") != "team-alice" {
+		t.Errorf("Aliases should outlive the webhook")
+	}
+	if _, err := wh.Claim("team-alice", "other"); err != internal.ErrClaimed {
+		t.Errorf("Expired webhooks should stay claimed, got %v", err)
+	}
+
+	if err := wh.Release("team-alice", "other"); err != internal.ErrClaimed {
+		t.Errorf("Only the owner should release a webhook, got %v", err)
+	}
+	if err := wh.Release("team-alice", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := wh.Claim("team-alice", "other"); !claimed || err != nil {
+		t.Errorf("Released webhooks should be claimable, got %v %v", claimed, err)
+	}
+}
+
+func TestSweepDeliveries(t *testing.T) {
+	wh := internal.NewWebhookHandler(&inMemBroker{})
+	wh.SetExpiration(internal.Expiration{Deliveries: time.Hour})
+	wh.Forward("abc123", nil, "a body")
+
+	if res := wh.Sweep(time.Now()); res.Deliveries != 0 {
+		t.Errorf("Recent deliveries should be kept")
+	}
+	if res := wh.Sweep(time.Now().Add(2 * time.Hour)); res.Deliveries != 1 {
+		t.Errorf("Old deliveries should be dropped, got %d", res.Deliveries)
+	}
+}
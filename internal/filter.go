@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Filter selects the deliveries a subscriber receives. Every expression
+	// must match: a JSON path like $.entry[*].changes[*].field=messages
+	// matches when any value it points to equals the text after =, and a
+	// header like X-App-Id=123 when the header has that value. Without =,
+	// the path or header only has to be present.
+	Filter struct {
+		predicates []predicate
+	}
+
+	predicate struct {
+		header string
+		path   []PathStep
+		value  string
+		exists bool
+	}
+
+	// document decodes a JSON body at most once, however many subscribers
+	// filter on it.
+	document struct {
+		body    string
+		decoded bool
+		v       interface{}
+		ok      bool
+	}
+)
+
+// ParseFilter parses filter expressions. An empty filter matches every
+// delivery.
+func ParseFilter(exprs ...string) (*Filter, error) {
+	f := &Filter{}
+	for _, expr := range exprs {
+		p := predicate{exists: true}
+		name := strings.TrimSpace(expr)
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, p.value, p.exists = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:]), false
+		}
+		if name == "" {
+			return nil, fmt.Errorf("Invalid filter \"%s\": missing path or header", expr)
+		}
+		if strings.HasPrefix(name, "$") {
+			path, err := ParsePath(name)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid filter \"%s\": %s", expr, err.Error())
+			}
+			p.path = path
+		} else {
+			p.header = http.CanonicalHeaderKey(name)
+		}
+		f.predicates = append(f.predicates, p)
+	}
+	return f, nil
+}
+
+// Match reports whether d passes the filter. A nil filter matches every
+// delivery.
+func (f *Filter) Match(d Delivery) bool {
+	return f.match(d.Header, &document{body: d.Body})
+}
+
+// match evaluates the filter, decoding the body of doc only if needed.
+func (f *Filter) match(header http.Header, doc *document) bool {
+	if f == nil {
+		return true
+	}
+	for _, p := range f.predicates {
+		if p.header != "" {
+			values, ok := header[p.header]
+			if !ok || (!p.exists && !contains(values, p.value)) {
+				return false
+			}
+			continue
+		}
+		v, ok := doc.decode()
+		if !ok || !p.matchJSON(Select(v, p.path)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p predicate) matchJSON(values []interface{}) bool {
+	if p.exists {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if jsonText(v) == p.value {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonText returns strings and numbers as they are, and other values as
+// JSON.
+func jsonText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (d *document) decode() (interface{}, bool) {
+	if !d.decoded {
+		d.decoded = true
+		dec := json.NewDecoder(strings.NewReader(d.body))
+		dec.UseNumber()
+		d.ok = dec.Decode(&d.v) == nil
+	}
+	return d.v, d.ok
+}
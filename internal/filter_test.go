@@ -0,0 +1,64 @@
+package internal_test
+
+import (
+	"net/http"
+	"testing"
+
+	"fbwhs/internal"
+)
+
+func TestFilter(t *testing.T) {
+	feed := internal.NewDelivery("wid", http.Header{"X-App-Id": {"456"}},
+		`{"object":"page","entry":[{"id":123,"changes":[{"field":"feed"}]},{"id":"789","changes":[{"field":"messages"}]}]}`)
+	for _, c := range []struct {
+		exprs []string
+		match bool
+	}{
+		{nil, true},
+		{[]string{"$.object=page"}, true},
+		{[]string{"$.object=instagram"}, false},
+		{[]string{"$.entry[*].id=123"}, true},
+		{[]string{"$.entry[0].id=789"}, false},
+		{[]string{"$.entry[*].changes[*].field=messages"}, true},
+		{[]string{"$.entry[*].messaging"}, false},
+		{[]string{"$.entry[*].changes"}, true},
+		{[]string{"X-App-Id=456"}, true},
+		{[]string{"x-app-id"}, true},
+		{[]string{"X-App-Id=1"}, false},
+		{[]string{"$.object=page", "X-Other"}, false},
+	} {
+		f, err := internal.ParseFilter(c.exprs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match := f.Match(feed); match != c.match {
+			t.Errorf("%v: expected %v, got %v", c.exprs, c.match, match)
+		}
+	}
+
+	f, _ := internal.ParseFilter("$.object=page")
+	if f.Match(internal.NewDelivery("wid", nil, "test=123")) {
+		t.Errorf("JSON filters should not match other bodies")
+	}
+	for _, expr := range []string{"=page", "$object", "$.entry[x]"} {
+		if _, err := internal.ParseFilter(expr); err == nil {
+			t.Errorf("%s should be rejected", expr)
+		}
+	}
+}
+
+func TestFilteredSubscription(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	f, _ := internal.ParseFilter("$.entry[*].id=123")
+	page, _ := wh.SubscribeWith("wid", internal.Subscription{Filter: f})
+	all, _ := wh.Subscribe("wid")
+
+	if n := wh.Deliver(internal.NewDelivery("wid", nil, `{"entry":[{"id":"789"}]}`)); n != 2 {
+		t.Errorf("Filtered subscribers should still be counted, got %d", n)
+	}
+	wh.Deliver(internal.NewDelivery("wid", nil, `{"entry":[{"id":"123"}]}`))
+	if len(b.to) != 3 || b.to[0] != all || b.to[1] != page || b.to[2] != all {
+		t.Errorf("Only matching deliveries should reach the filtered subscriber, got %v", b.to)
+	}
+}
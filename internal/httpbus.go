@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ClusterPath         = "/cluster/deliveries"
+	ClusterSecretHeader = "X-Fbwhs-Cluster-Secret"
+	ClusterTimeout      = 5 * time.Second
+)
+
+var ErrNoClusterSecret = errors.New("A cluster secret is required")
+
+type (
+	// HTTPBus publishes deliveries to peer nodes by POSTing them to their
+	// ClusterPath. Peers are base URLs of other relays, e.g.
+	// http://10.0.0.2:5000. A peer that cannot be reached is logged and
+	// skipped.
+	HTTPBus struct {
+		sync.Mutex
+		peers   []string
+		secret  string
+		client  *http.Client
+		handler func(d Delivery) int
+	}
+
+	clusterResponse struct {
+		Delivered int `json:"delivered"`
+	}
+)
+
+// NewHTTPBus refuses an empty secret, which would let anyone publish
+// deliveries to the node.
+func NewHTTPBus(peers []string, secret string) (*HTTPBus, error) {
+	if secret == "" {
+		return nil, ErrNoClusterSecret
+	}
+	return &HTTPBus{
+		peers:  peers,
+		secret: secret,
+		client: &http.Client{Timeout: ClusterTimeout},
+	}, nil
+}
+
+func (b *HTTPBus) Handle(fn func(d Delivery) int) {
+	b.Lock()
+	defer b.Unlock()
+	b.handler = fn
+}
+
+func (b *HTTPBus) SetPeers(peers []string) {
+	b.Lock()
+	defer b.Unlock()
+	b.peers = peers
+}
+
+func (b *HTTPBus) Publish(d Delivery) (int, error) {
+	body, err := json.Marshal(d)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to encode delivery to json")
+	}
+
+	b.Lock()
+	peers, handler := b.peers, b.handler
+	b.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	n := 0
+	if handler != nil {
+		n = handler(d)
+	}
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			delivered, err := b.publishTo(peer, body)
+			if err != nil {
+				log.Printf("Unable to publish delivery %s to peer %s: %s", d.ID, peer, err.Error())
+				return
+			}
+			mu.Lock()
+			n += delivered
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return n, nil
+}
+
+func (b *HTTPBus) publishTo(peer string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(peer, "/")+ClusterPath, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ClusterSecretHeader, b.secret)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Peer responded with %d", resp.StatusCode)
+	}
+	var res clusterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return 0, err
+	}
+	return res.Delivered, nil
+}
+
+// ServeHTTP receives deliveries published by peers and hands them to the
+// local handler only.
+func (b *HTTPBus) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get(ClusterSecretHeader)
+	if b.secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(b.secret)) != 1 {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var d Delivery
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b.Lock()
+	handler := b.handler
+	b.Unlock()
+
+	var res clusterResponse
+	if handler != nil {
+		res.Delivered = handler(d)
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(res)
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrBodyTooLarge = errors.New("Request body too large")
+	ErrSlowRequest  = errors.New("Request body read timed out")
+
+	DefaultLimits = Limits{
+		MaxBodySize:       1 << 20,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+)
+
+type (
+	// Limits protects the server from large and slow requests. ReadTimeout
+	// and WriteTimeout are applied per request by Handler and cleared by
+	// Stream, so that long-lived SSE connections are only bound by the
+	// broker's own timeouts.
+	Limits struct {
+		MaxBodySize       int64
+		ReadHeaderTimeout time.Duration
+		ReadTimeout       time.Duration
+		WriteTimeout      time.Duration
+		IdleTimeout       time.Duration
+	}
+
+	Limiter struct {
+		Limits
+		oversized int64
+		slow      int64
+	}
+)
+
+func NewLimiter(l Limits) *Limiter {
+	return &Limiter{Limits: l}
+}
+
+// Server applies the connection level limits. Per request limits are up to
+// Handler and Stream.
+func (l *Limiter) Server(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: l.ReadHeaderTimeout,
+		IdleTimeout:       l.IdleTimeout,
+	}
+}
+
+// Stream serves long-lived requests without read and write deadlines.
+func (l *Limiter) Stream(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Deadlines stick to the connection, clear whatever a previous
+		// request on it has set.
+		rc := http.NewResponseController(rw)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		h.ServeHTTP(rw, r)
+	})
+}
+
+func (l *Limiter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(rw)
+		now := time.Now()
+		if l.ReadTimeout > 0 {
+			rc.SetReadDeadline(now.Add(l.ReadTimeout))
+		}
+		if l.WriteTimeout > 0 {
+			rc.SetWriteDeadline(now.Add(l.WriteTimeout))
+		} else {
+			rc.SetWriteDeadline(time.Time{})
+		}
+
+		if l.MaxBodySize > 0 {
+			if r.ContentLength > l.MaxBodySize {
+				l.rejectOversized(r)
+				http.Error(rw, ErrBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(rw, r.Body, l.MaxBodySize)
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// ReadBody reads the whole request body, returning ErrBodyTooLarge or
+// ErrSlowRequest if the client exceeded the limits.
+func (l *Limiter) ReadBody(r *http.Request) (string, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		return string(b), nil
+	}
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		l.rejectOversized(r)
+		return "", ErrBodyTooLarge
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		n := atomic.AddInt64(&l.slow, 1)
+		log.Printf("Rejected slow request to %s from %s, %d so far", r.URL.Path, r.RemoteAddr, n)
+		return "", ErrSlowRequest
+	}
+	return "", err
+}
+
+// Stats returns how many oversized and slow requests have been rejected.
+func (l *Limiter) Stats() (oversized, slow int64) {
+	return atomic.LoadInt64(&l.oversized), atomic.LoadInt64(&l.slow)
+}
+
+func (l *Limiter) rejectOversized(r *http.Request) {
+	n := atomic.AddInt64(&l.oversized, 1)
+	log.Printf("Rejected oversized request to %s from %s, %d so far", r.URL.Path, r.RemoteAddr, n)
+}
@@ -0,0 +1,99 @@
+package internal_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fbwhs/internal"
+)
+
+func newLimitServer(l internal.Limits) (*httptest.Server, *internal.Limiter) {
+	limiter := internal.NewLimiter(l)
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := limiter.ReadBody(r)
+		switch err {
+		case nil:
+			fmt.Fprint(rw, body)
+		case internal.ErrBodyTooLarge:
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+		case internal.ErrSlowRequest:
+			rw.WriteHeader(http.StatusRequestTimeout)
+		default:
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	return httptest.NewServer(limiter.Handler(h)), limiter
+}
+
+func TestLimiterBodySize(t *testing.T) {
+	srv, limiter := newLimitServer(internal.Limits{MaxBodySize: 4})
+	defer srv.Close()
+
+	resp, _ := http.Post(srv.URL, "text/plain", strings.NewReader("1234"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Body within limit should be accepted, got %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Post(srv.URL, "text/plain", strings.NewReader("12345"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized body should be rejected, got %d", resp.StatusCode)
+	}
+
+	// Without a Content-Length the body is cut off while reading
+	resp, _ = http.Post(srv.URL, "text/plain", bufio.NewReader(strings.NewReader("12345")))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized chunked body should be rejected, got %d", resp.StatusCode)
+	}
+
+	if oversized, _ := limiter.Stats(); oversized != 2 {
+		t.Errorf("Oversized requests should be counted, got %d", oversized)
+	}
+}
+
+func TestLimiterSlowBody(t *testing.T) {
+	srv, limiter := newLimitServer(internal.Limits{ReadTimeout: 50 * time.Millisecond})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Length: 10\r\n\r\n123")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("Slow body should be rejected, got %d", resp.StatusCode)
+	}
+	if _, slow := limiter.Stats(); slow != 1 {
+		t.Errorf("Slow requests should be counted, got %d", slow)
+	}
+}
+
+func TestLimiterSkipsStreams(t *testing.T) {
+	limiter := internal.NewLimiter(internal.Limits{MaxBodySize: 1, WriteTimeout: time.Millisecond})
+	srv := httptest.NewServer(limiter.Stream(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		body, _ := limiter.ReadBody(r)
+		fmt.Fprint(rw, body)
+	})))
+	defer srv.Close()
+
+	resp, _ := http.Post(srv.URL, "text/plain", strings.NewReader("12345"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Stream paths should not be limited, got %d", resp.StatusCode)
+	}
+}
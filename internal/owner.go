@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"regexp"
+)
+
+const SecretHeader = "X-Fbwhs-Secret"
+
+var (
+	ErrInvalidName = errors.New("Names must be 1 to 64 letters, digits, dots, dashes or underscores")
+	ErrNoSecret    = errors.New("Secret is required")
+	ErrClaimed     = errors.New("Webhook is claimed by someone else")
+	ErrNameTaken   = errors.New("Name is already in use")
+	ErrIsAlias     = errors.New("Webhook is an alias")
+	ErrNotAlias    = errors.New("Name is not an alias of this webhook")
+
+	validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Resolve returns the webhook an alias points to, or webhookID itself.
+// Resolving an alias counts as activity on it.
+func (wh *WebhookHandler) Resolve(webhookID string) string {
+	c, ok := wh.Config(webhookID)
+	if !ok || c.AliasOf == "" {
+		return webhookID
+	}
+	wh.Touch(webhookID)
+	return c.AliasOf
+}
+
+// Claim reserves a webhook for the owner of secret. It reports whether the
+// webhook was newly claimed; claiming it again with the same secret succeeds.
+func (wh *WebhookHandler) Claim(webhookID, secret string) (bool, error) {
+	if !ValidName(webhookID) {
+		return false, ErrInvalidName
+	}
+	if secret == "" {
+		return false, ErrNoSecret
+	}
+
+	claimed := false
+	_, err := wh.UpdateConfig(webhookID, func(c *WebhookConfig) error {
+		switch {
+		case c.AliasOf != "":
+			return ErrIsAlias
+		case c.Owner == "":
+			c.Owner = hashSecret(secret)
+			claimed = true
+		case !sameHash(c.Owner, hashSecret(secret)):
+			return ErrClaimed
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+// Release gives up the claim on a webhook, so that it can be claimed again.
+// Claims are never expired, so this is the only way to drop them.
+func (wh *WebhookHandler) Release(webhookID, secret string) error {
+	_, err := wh.UpdateConfig(webhookID, func(c *WebhookConfig) error {
+		if c.Owner != "" && !sameHash(c.Owner, hashSecret(secret)) {
+			return ErrClaimed
+		}
+		c.Owner = ""
+		return nil
+	})
+	return err
+}
+
+// IsOwner reports whether secret grants access to a webhook. Anybody owns an
+// unclaimed webhook.
+func (wh *WebhookHandler) IsOwner(webhookID, secret string) bool {
+	c, ok := wh.Config(webhookID)
+	if !ok || c.Owner == "" {
+		return true
+	}
+	return sameHash(c.Owner, hashSecret(secret))
+}
+
+// SetAlias points name at a webhook. The name must not be in use already,
+// except as an alias of the same webhook.
+func (wh *WebhookHandler) SetAlias(name, webhookID string) error {
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+	webhookID = wh.Resolve(webhookID)
+	if name == webhookID || len(wh.EventIDs(name)) > 0 {
+		return ErrNameTaken
+	}
+	_, err := wh.UpdateConfig(name, func(c *WebhookConfig) error {
+		if c.AliasOf == webhookID {
+			return nil
+		}
+		if c.AliasOf != "" || c.Owner != "" || len(c.Routes) > 0 || len(c.AllowFrom) > 0 || len(c.Targets) > 0 {
+			return ErrNameTaken
+		}
+		c.AliasOf = webhookID
+		return nil
+	})
+	return err
+}
+
+func (wh *WebhookHandler) RemoveAlias(name, webhookID string) error {
+	webhookID = wh.Resolve(webhookID)
+	_, err := wh.configStore.Update(name, func(c *WebhookConfig) error {
+		if c.AliasOf == "" || c.AliasOf != webhookID {
+			return ErrNotAlias
+		}
+		c.AliasOf = ""
+		return nil
+	})
+	return err
+}
+
+func sameHash(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
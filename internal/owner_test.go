@@ -0,0 +1,69 @@
+package internal_test
+
+import (
+	"testing"
+
+	"fbwhs/internal"
+)
+
+func TestClaim(t *testing.T) {
+	wh := internal.NewWebhookHandler(&inMemBroker{})
+	wid := "team-alice-messenger"
+	if !wh.IsOwner(wid, "") {
+		t.Errorf("Anybody should own an unclaimed webhook")
+	}
+	if claimed, err := wh.Claim(wid, "s3cret"); !claimed || err != nil {
+		t.Fatalf("Webhook should be claimed, got %v %v", claimed, err)
+	}
+	if claimed, err := wh.Claim(wid, "s3cret"); claimed || err != nil {
+		t.Errorf("Claiming again should succeed, got %v %v", claimed, err)
+	}
+	if _, err := wh.Claim(wid, "other"); err != internal.ErrClaimed {
+		t.Errorf("Should return ErrClaimed, got %v", err)
+	}
+	if wh.IsOwner(wid, "other") || !wh.IsOwner(wid, "s3cret") {
+		t.Errorf("Only the owner of the secret should own the webhook")
+	}
+	if _, err := wh.Claim("not/a/name", "s3cret"); err != internal.ErrInvalidName {
+		t.Errorf("Should return ErrInvalidName, got %v", err)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	wid := "1HbA4TRlBeiS1nrfu5siRdgma7c"
+	if err := wh.SetAlias("team", wid); err != nil {
+		t.Fatal(err)
+	}
+	if err := wh.SetAlias("team-again", "team"); err != nil {
+		t.Fatal(err)
+	}
+	if wh.Resolve("team") != wid || wh.Resolve("team-again") != wid {
+		t.Errorf("Aliases should resolve to the webhook")
+	}
+	if wh.Resolve(wid) != wid {
+		t.Errorf("Webhooks should resolve to themselves")
+	}
+
+	if err := wh.SetAlias("team", "other"); err != internal.ErrNameTaken {
+		t.Errorf("Should return ErrNameTaken, got %v", err)
+	}
+	wh.Claim("claimed", "s3cret")
+	if err := wh.SetAlias("claimed", wid); err != internal.ErrNameTaken {
+		t.Errorf("Claimed names should not become aliases, got %v", err)
+	}
+	if _, err := wh.Claim("team", "s3cret"); err != internal.ErrIsAlias {
+		t.Errorf("Aliases should not be claimed, got %v", err)
+	}
+
+	if err := wh.RemoveAlias("team", "other"); err != internal.ErrNotAlias {
+		t.Errorf("Should return ErrNotAlias, got %v", err)
+	}
+	if err := wh.RemoveAlias("team", wid); err != nil {
+		t.Fatal(err)
+	}
+	if wh.Resolve("team") != "team" {
+		t.Errorf("Removed aliases should not resolve")
+	}
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathStep is a step of a JSON path, an object field or array elements.
+type PathStep struct {
+	Key   string
+	Index int
+	Array bool
+	All   bool
+}
+
+// ParsePath parses a JSON path starting at the root $, selecting object
+// fields with .name and array elements with [0] or [*].
+func ParsePath(p string) ([]PathStep, error) {
+	if !strings.HasPrefix(p, "$") {
+		return nil, errors.New("paths start with $")
+	}
+	var path []PathStep
+	p = p[1:]
+	for p != "" {
+		switch p[0] {
+		case '.':
+			end := strings.IndexAny(p[1:], ".[")
+			if end < 0 {
+				end = len(p) - 1
+			}
+			if end == 0 {
+				return nil, errors.New("empty field name")
+			}
+			path = append(path, PathStep{Key: p[1 : end+1]})
+			p = p[end+1:]
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, errors.New("missing ]")
+			}
+			s := PathStep{Array: true}
+			if index := p[1:end]; index == "*" {
+				s.All = true
+			} else if n, err := strconv.Atoi(index); err == nil && n >= 0 {
+				s.Index = n
+			} else {
+				return nil, fmt.Errorf("invalid index %s", index)
+			}
+			path = append(path, s)
+			p = p[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", p[0])
+		}
+	}
+	if len(path) == 0 {
+		return nil, errors.New("the root cannot be selected")
+	}
+	return path, nil
+}
+
+// Select returns the values path points to in a decoded JSON document.
+func Select(v interface{}, path []PathStep) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	s := path[0]
+	switch c := v.(type) {
+	case map[string]interface{}:
+		if e, ok := c[s.Key]; ok && !s.Array {
+			return Select(e, path[1:])
+		}
+	case []interface{}:
+		if !s.Array {
+			return nil
+		}
+		var values []interface{}
+		for i, e := range c {
+			if s.All || i == s.Index {
+				values = append(values, Select(e, path[1:])...)
+			}
+		}
+		return values
+	}
+	return nil
+}
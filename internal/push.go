@@ -0,0 +1,312 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DeliveryHeader carries the delivery ID on pushes, so that targets can
+	// tell retries apart from new deliveries.
+	DeliveryHeader = "X-Fbwhs-Delivery"
+	PushLogSize    = 100
+	// MaxTargets bounds how many targets every delivery of a webhook is
+	// pushed to.
+	MaxTargets = 10
+)
+
+var (
+	ErrInvalidTarget  = errors.New("Targets must be absolute http or https URLs")
+	ErrPrivateTarget  = errors.New("Private addresses cannot be push targets")
+	ErrPushDropped    = errors.New("Too many pending pushes, dropped")
+	ErrTooManyTargets = fmt.Errorf("A webhook can have at most %d targets", MaxTargets)
+
+	DefaultPushOptions = PushOptions{
+		Retries:    3,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+		Timeout:    10 * time.Second,
+		MaxPending: 1000,
+	}
+
+	// sharedAddressSpace is the carrier-grade NAT range, which IsPrivate
+	// leaves out.
+	sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+	// pushDeniedHeaders are hop-by-hop headers and those the HTTP client sets
+	// itself.
+	pushDeniedHeaders = []string{
+		"Accept-Encoding", "Connection", "Content-Length", "Host", "Keep-Alive",
+		"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	}
+)
+
+type (
+	// Target is a URL the relay POSTs the deliveries of a webhook to, with
+	// their original headers, alongside its subscribers.
+	Target struct {
+		URL string `json:"url"`
+		// Group receives the deliveries routed to it, like a subscriber
+		// group.
+		Group string `json:"group,omitempty"`
+	}
+
+	// PushOptions tunes how deliveries are pushed to targets. Failed pushes,
+	// on network errors, 429 and 5xx responses, are retried up to Retries
+	// times, waiting MinBackoff first and twice as long every next time, up
+	// to MaxBackoff.
+	PushOptions struct {
+		Retries    int
+		MinBackoff time.Duration
+		MaxBackoff time.Duration
+		// Timeout bounds every attempt.
+		Timeout time.Duration
+		// MaxPending bounds the pushes in flight or waiting for a retry.
+		// Pushes beyond it are dropped and counted as failed.
+		MaxPending int
+		// AllowPrivate allows targets on loopback, private, shared and
+		// link-local addresses, which are refused by default so that webhook owners
+		// cannot reach the relay's own network.
+		AllowPrivate bool
+	}
+
+	// PushAttempt is an entry of the push log of a webhook.
+	PushAttempt struct {
+		DeliveryID string    `json:"delivery_id"`
+		URL        string    `json:"url"`
+		Attempt    int       `json:"attempt"`
+		StatusCode int       `json:"status_code,omitempty"`
+		Error      string    `json:"error,omitempty"`
+		Duration   int64     `json:"duration_ms"`
+		At         time.Time `json:"at"`
+		// Retry tells whether another attempt follows.
+		Retry bool `json:"retry,omitempty"`
+	}
+
+	// Pusher pushes deliveries to targets in the background and logs the
+	// last PushLogSize attempts of every webhook. The log is local to the
+	// node that received the delivery.
+	Pusher struct {
+		sync.Mutex
+		opts   PushOptions
+		client *http.Client
+		logs   map[string][]PushAttempt
+		// queued counts the pushes waiting for a retry, failed those that
+		// were given up on.
+		queued map[string]int
+		failed map[string]int64
+		slots  chan struct{}
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	}
+)
+
+// Validate checks that the target is an absolute http or https URL.
+func (t Target) Validate() error {
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidTarget
+	}
+	return nil
+}
+
+func NewPusher(o PushOptions) *Pusher {
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = DefaultPushOptions.MinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultPushOptions.Timeout
+	}
+	if o.MaxPending <= 0 {
+		o.MaxPending = DefaultPushOptions.MaxPending
+	}
+
+	dialer := &net.Dialer{Timeout: o.Timeout}
+	if !o.AllowPrivate {
+		// Checking the address being dialed, rather than the target host,
+		// also covers redirects and DNS answers changing under our feet.
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || private(ip) {
+				return ErrPrivateTarget
+			}
+			return nil
+		}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = dialer.DialContext
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pusher{
+		opts:   o,
+		client: &http.Client{Transport: t, Timeout: o.Timeout},
+		logs:   make(map[string][]PushAttempt),
+		queued: make(map[string]int),
+		failed: make(map[string]int64),
+		slots:  make(chan struct{}, o.MaxPending),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func private(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// Push delivers d to t in the background, retrying failed attempts, unless
+// MaxPending pushes are pending already.
+func (p *Pusher) Push(d Delivery, t Target) {
+	// Adding under the lock keeps Close from waiting before we are counted.
+	p.Lock()
+	if p.ctx.Err() != nil {
+		p.Unlock()
+		return
+	}
+	p.wg.Add(1)
+	p.Unlock()
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		p.wg.Done()
+		log.Printf("Push of %s to %s dropped", d.ID, t.URL)
+		p.log(d.WebhookID, PushAttempt{DeliveryID: d.ID, URL: t.URL, Error: ErrPushDropped.Error(), At: time.Now()})
+		p.count(d.WebhookID, 0, 1)
+		return
+	}
+	go func() {
+		defer func() {
+			<-p.slots
+			p.wg.Done()
+		}()
+		backoff := p.opts.MinBackoff
+		for attempt := 1; ; attempt++ {
+			a, retry := p.push(d, t, attempt)
+			a.Retry = retry && attempt <= p.opts.Retries
+			p.log(d.WebhookID, a)
+			if !a.Retry {
+				if a.Error != "" {
+					log.Printf("Push of %s to %s failed: %s", d.ID, t.URL, a.Error)
+					p.count(d.WebhookID, 0, 1)
+				}
+				return
+			}
+			p.count(d.WebhookID, 1, 0)
+			select {
+			case <-p.ctx.Done():
+				p.count(d.WebhookID, -1, 0)
+				return
+			case <-time.After(backoff):
+			}
+			p.count(d.WebhookID, -1, 0)
+			if backoff *= 2; backoff > p.opts.MaxBackoff {
+				backoff = p.opts.MaxBackoff
+			}
+		}
+	}()
+}
+
+// push makes one attempt and reports whether it is worth retrying.
+func (p *Pusher) push(d Delivery, t Target, attempt int) (PushAttempt, bool) {
+	a := PushAttempt{DeliveryID: d.ID, URL: t.URL, Attempt: attempt, At: time.Now()}
+	req, err := http.NewRequestWithContext(p.ctx, "POST", t.URL, strings.NewReader(d.Body))
+	if err != nil {
+		a.Error = err.Error()
+		return a, false
+	}
+	req.Header = d.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	for _, k := range pushDeniedHeaders {
+		req.Header.Del(k)
+	}
+	req.Header.Set(DeliveryHeader, d.ID)
+
+	resp, err := p.client.Do(req)
+	a.Duration = time.Since(a.At).Milliseconds()
+	if err != nil {
+		a.Error = err.Error()
+		return a, !errors.Is(err, ErrPrivateTarget) && p.ctx.Err() == nil
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode >= http.StatusBadRequest {
+		a.Error = fmt.Sprintf("Target responded with %d", resp.StatusCode)
+	}
+	return a, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func (p *Pusher) log(webhookID string, a PushAttempt) {
+	p.Lock()
+	defer p.Unlock()
+	entries := append(p.logs[webhookID], a)
+	if len(entries) > PushLogSize {
+		entries = entries[len(entries)-PushLogSize:]
+	}
+	p.logs[webhookID] = entries
+}
+
+func (p *Pusher) count(webhookID string, queued int, failed int64) {
+	p.Lock()
+	defer p.Unlock()
+	if p.queued[webhookID] += queued; p.queued[webhookID] <= 0 {
+		delete(p.queued, webhookID)
+	}
+	if failed > 0 {
+		p.failed[webhookID] += failed
+	}
+}
+
+// Counts returns how many pushes of a webhook wait for a retry, and how
+// many failed after their last attempt.
+func (p *Pusher) Counts(webhookID string) (queued int, failed int64) {
+	p.Lock()
+	defer p.Unlock()
+	return p.queued[webhookID], p.failed[webhookID]
+}
+
+// Log returns the last push attempts of a webhook, oldest first.
+func (p *Pusher) Log(webhookID string) []PushAttempt {
+	p.Lock()
+	defer p.Unlock()
+	return append([]PushAttempt(nil), p.logs[webhookID]...)
+}
+
+// Forget drops the log and failure count of a webhook.
+func (p *Pusher) Forget(webhookID string) bool {
+	p.Lock()
+	defer p.Unlock()
+	_, ok := p.logs[webhookID]
+	delete(p.logs, webhookID)
+	delete(p.failed, webhookID)
+	return ok
+}
+
+// Close abandons pending retries and waits for the pushes in flight.
+func (p *Pusher) Close() {
+	p.Lock()
+	p.cancel()
+	p.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,139 @@
+package internal_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fbwhs/internal"
+)
+
+func waitLog(t *testing.T, p *internal.Pusher, wid string, n int) []internal.PushAttempt {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		attempts := p.Log(wid)
+		if len(attempts) >= n {
+			return attempts
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d push attempts, got %+v", n, attempts)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPusher(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(internal.DeliveryHeader) == "" || r.Header.Get("X-Hub-Signature") != "sha1=abc" {
+			t.Errorf("Push should carry the delivery headers, got %v", r.Header)
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	p := internal.NewPusher(internal.PushOptions{Retries: 3, MinBackoff: time.Millisecond, AllowPrivate: true})
+	defer p.Close()
+	d := internal.NewDelivery("wid", http.Header{"X-Hub-Signature": {"sha1=abc"}}, "test=123")
+	p.Push(d, internal.Target{URL: srv.URL})
+
+	attempts := waitLog(t, p, "wid", 3)
+	if attempts[0].StatusCode != http.StatusServiceUnavailable || !attempts[0].Retry {
+		t.Errorf("First attempt should fail and be retried, got %+v", attempts[0])
+	}
+	if last := attempts[2]; last.StatusCode != http.StatusOK || last.Retry || last.Error != "" || last.Attempt != 3 {
+		t.Errorf("Third attempt should succeed, got %+v", last)
+	}
+
+	if !p.Forget("wid") || len(p.Log("wid")) != 0 {
+		t.Errorf("Forget should drop the log")
+	}
+}
+
+func TestPusherRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	p := internal.NewPusher(internal.PushOptions{Retries: 1, MinBackoff: time.Millisecond, AllowPrivate: true})
+	defer p.Close()
+	p.Push(internal.NewDelivery("wid", nil, "{}"), internal.Target{URL: srv.URL})
+
+	attempts := waitLog(t, p, "wid", 2)
+	time.Sleep(20 * time.Millisecond)
+	if attempts = p.Log("wid"); len(attempts) != 2 || attempts[1].Retry {
+		t.Errorf("Pushes should stop after the retries, got %+v", attempts)
+	}
+	if queued, failed := p.Counts("wid"); queued != 0 || failed != 1 {
+		t.Errorf("Failed pushes should be counted, got %d queued and %d failed", queued, failed)
+	}
+}
+
+func TestPusherMaxPending(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	p := internal.NewPusher(internal.PushOptions{MaxPending: 1, AllowPrivate: true})
+	defer p.Close()
+	p.Push(internal.NewDelivery("wid", nil, "1"), internal.Target{URL: srv.URL})
+	p.Push(internal.NewDelivery("wid", nil, "2"), internal.Target{URL: srv.URL})
+
+	attempts := waitLog(t, p, "wid", 1)
+	if attempts[0].Error != internal.ErrPushDropped.Error() {
+		t.Errorf("Pushes beyond MaxPending should be dropped, got %+v", attempts[0])
+	}
+	if _, failed := p.Counts("wid"); failed != 1 {
+		t.Errorf("Dropped pushes should be counted, got %d", failed)
+	}
+	close(release)
+	waitLog(t, p, "wid", 2)
+}
+
+func TestPusherClosed(t *testing.T) {
+	p := internal.NewPusher(internal.PushOptions{AllowPrivate: true})
+	p.Close()
+	p.Push(internal.NewDelivery("wid", nil, "{}"), internal.Target{URL: "http://127.0.0.1:1"})
+	time.Sleep(10 * time.Millisecond)
+	if attempts := p.Log("wid"); len(attempts) != 0 {
+		t.Errorf("Closed pushers should not push, got %+v", attempts)
+	}
+}
+
+func TestPusherPrivate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Private targets should not be reached")
+	}))
+	defer srv.Close()
+
+	p := internal.NewPusher(internal.DefaultPushOptions)
+	defer p.Close()
+	p.Push(internal.NewDelivery("wid", nil, "{}"), internal.Target{URL: srv.URL})
+
+	attempts := waitLog(t, p, "wid", 1)
+	if attempts[0].Error == "" || attempts[0].Retry {
+		t.Errorf("Private targets should fail without retries, got %+v", attempts[0])
+	}
+}
+
+func TestTargetValidate(t *testing.T) {
+	for url, valid := range map[string]bool{
+		"https://example.com/hook": true,
+		"http://example.com":       true,
+		"ftp://example.com":        false,
+		"/hook":                    false,
+		"https://":                 false,
+	} {
+		if err := (internal.Target{URL: url}).Validate(); (err == nil) != valid {
+			t.Errorf("Validate(%s) = %v", url, err)
+		}
+	}
+}
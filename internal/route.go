@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const RoutedFromHeader = "X-Fbwhs-Routed-From"
+
+type (
+	// Route sends the deliveries of a webhook that match all of its criteria
+	// to a subscriber group, of this webhook or of the downstream webhook To.
+	// Batched deliveries are sent to every route matching one of their
+	// entries, unmodified so that their signature stays valid.
+	Route struct {
+		// Page matches the ID of an entry, i.e. the page or object ID.
+		Page string `json:"page,omitempty"`
+		// Recipient matches the recipient ID of a Messenger event.
+		Recipient string `json:"recipient,omitempty"`
+		// Header matches request headers, e.g. an app ID header.
+		Header map[string]string `json:"header,omitempty"`
+
+		To    string `json:"to,omitempty"`
+		Group string `json:"group,omitempty"`
+	}
+
+	notification struct {
+		Entry []struct {
+			ID        flexString       `json:"id"`
+			Messaging []messagingEvent `json:"messaging"`
+			Standby   []messagingEvent `json:"standby"`
+		} `json:"entry"`
+	}
+
+	messagingEvent struct {
+		Recipient struct {
+			ID flexString `json:"id"`
+		} `json:"recipient"`
+	}
+
+	// flexString accepts IDs sent either as JSON strings or numbers.
+	flexString string
+
+	// AllowFunc reports whether the sender of a delivery may post to a
+	// webhook with a non-empty allowlist.
+	AllowFunc func(webhookID string, allowFrom []string) bool
+
+	routeTarget struct {
+		webhookID string
+		groups    []string
+	}
+)
+
+func (r Route) Validate(webhookID string) error {
+	if r.Page == "" && r.Recipient == "" && len(r.Header) == 0 {
+		return fmt.Errorf("Route needs at least one of page, recipient or header")
+	}
+	if r.To == "" && r.Group == "" {
+		return fmt.Errorf("Route needs a target webhook or group")
+	}
+	if r.To == webhookID {
+		return fmt.Errorf("Route cannot target its own webhook, use a group instead")
+	}
+	return nil
+}
+
+func (r Route) matches(header http.Header, n *notification) bool {
+	for k, v := range r.Header {
+		if header.Get(k) != v {
+			return false
+		}
+	}
+	if r.Page == "" && r.Recipient == "" {
+		return true
+	}
+	if n == nil {
+		return false
+	}
+
+	for _, e := range n.Entry {
+		if r.Page != "" && string(e.ID) != r.Page {
+			continue
+		}
+		if r.Recipient == "" {
+			return true
+		}
+		for _, events := range [][]messagingEvent{e.Messaging, e.Standby} {
+			for _, m := range events {
+				if string(m.Recipient.ID) == r.Recipient {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// route applies the routes of the webhook to d, returning the deliveries to
+// publish. Deliveries to downstream webhooks are not routed any further, and
+// are dropped unless allow, if set, lets the sender through their allowlist.
+func (wh *WebhookHandler) route(d Delivery, allow AllowFunc) []Delivery {
+	c, ok := wh.Config(d.WebhookID)
+	if !ok || len(c.Routes) == 0 {
+		return []Delivery{d}
+	}
+
+	var n *notification
+	if err := json.Unmarshal([]byte(d.Body), &n); err != nil {
+		n = nil
+	}
+
+	var targets []*routeTarget
+	byWebhook := make(map[string]*routeTarget)
+	matched := false
+	for _, r := range c.Routes {
+		if !r.matches(d.Header, n) {
+			continue
+		}
+		matched = true
+		wid := wh.Resolve(r.To)
+		if wid == "" {
+			wid = d.WebhookID
+		}
+		if wid != d.WebhookID && allow != nil {
+			if c, _ := wh.Config(wid); len(c.AllowFrom) > 0 && !allow(wid, c.AllowFrom) {
+				wh.CountError(wid, ErrorDenied)
+				continue
+			}
+		}
+		t, ok := byWebhook[wid]
+		if !ok {
+			t = &routeTarget{webhookID: wid}
+			byWebhook[wid] = t
+			targets = append(targets, t)
+		}
+		if !contains(t.groups, r.Group) {
+			t.groups = append(t.groups, r.Group)
+		}
+	}
+	if !matched {
+		return []Delivery{d}
+	}
+
+	ds := make([]Delivery, len(targets))
+	for i, t := range targets {
+		if t.webhookID == d.WebhookID {
+			ds[i] = d
+		} else {
+			header := d.Header.Clone()
+			if header == nil {
+				header = make(http.Header)
+			}
+			header.Set(RoutedFromHeader, d.WebhookID)
+			ds[i] = NewDelivery(t.webhookID, header, d.Body)
+		}
+		ds[i].Groups = t.groups
+	}
+	return ds
+}
+
+// inGroups reports whether a subscriber group is part of groups, where no
+// groups stands for the default, empty, group.
+func inGroups(group string, groups []string) bool {
+	if len(groups) == 0 {
+		return group == ""
+	}
+	return contains(groups, group)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *flexString) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte(`"`)) {
+		var str string
+		if err := json.Unmarshal(b, &str); err != nil {
+			return err
+		}
+		*s = flexString(str)
+		return nil
+	}
+	var num json.Number
+	if err := json.Unmarshal(b, &num); err != nil {
+		return err
+	}
+	*s = flexString(num.String())
+	return nil
+}
@@ -0,0 +1,128 @@
+package internal_test
+
+import (
+	"net/http"
+	"testing"
+
+	"fbwhs/internal"
+)
+
+const pageEvent = `{"object":"page","entry":[{"id":123,"messaging":[{"recipient":{"id":"456"}}]}]}`
+
+func setRoutes(t *testing.T, wh *internal.WebhookHandler, wid string, routes ...internal.Route) {
+	t.Helper()
+	_, err := wh.UpdateConfig(wid, func(c *internal.WebhookConfig) error {
+		c.Routes = routes
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouteToGroup(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	wid := "abc123"
+	setRoutes(t, wh, wid, internal.Route{Page: "123", Group: "alice"})
+	alice, _ := wh.SubscribeWith(wid, internal.Subscription{Group: "alice"})
+	rest, _ := wh.Subscribe(wid)
+
+	if err := wh.Forward(wid, nil, pageEvent); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.to) != 1 || b.to[0] != alice {
+		t.Errorf("Matching page should only go to its group, got %v", b.to)
+	}
+
+	b.to = nil
+	if err := wh.Forward(wid, nil, `{"entry":[{"id":"789"}]}`); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.to) != 1 || b.to[0] != rest {
+		t.Errorf("Unmatched deliveries should go to ungrouped subscribers, got %v", b.to)
+	}
+}
+
+func TestRouteRecipientAndHeader(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	wid := "abc123"
+	setRoutes(t, wh, wid,
+		internal.Route{Recipient: "456", Group: "bob"},
+		internal.Route{Header: map[string]string{"X-App-Id": "42"}, Group: "carol"},
+	)
+	bob, _ := wh.SubscribeWith(wid, internal.Subscription{Group: "bob"})
+	carol, _ := wh.SubscribeWith(wid, internal.Subscription{Group: "carol"})
+
+	wh.Forward(wid, http.Header{"X-App-Id": []string{"42"}}, pageEvent)
+	if len(b.to) != 2 || b.to[0] != bob || b.to[1] != carol {
+		t.Errorf("Delivery should go to every matching group, got %v", b.to)
+	}
+
+	b.to = nil
+	if wh.Forward(wid, nil, `{"entry":[{"id":"123"}]}`) == nil {
+		t.Errorf("Delivery without a subscribed group should not be delivered")
+	}
+}
+
+func TestRouteDownstream(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	setRoutes(t, wh, "team", internal.Route{Page: "123", To: "alice"})
+	setRoutes(t, wh, "alice", internal.Route{Page: "123", To: "team"})
+	wh.Subscribe("team")
+	alice, _ := wh.Subscribe("alice")
+
+	if err := wh.Forward("team", nil, pageEvent); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.to) != 1 || b.to[0] != alice {
+		t.Fatalf("Delivery should only go to the downstream webhook, got %v", b.to)
+	}
+	d, ok := lastDelivery(wh, b, "alice")
+	if !ok {
+		t.Fatalf("Downstream delivery should be retained")
+	}
+	if d.Header.Get(internal.RoutedFromHeader) != "team" {
+		t.Errorf("Downstream delivery should be marked with its origin")
+	}
+}
+
+func TestRouteDownstreamAllowlist(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	setRoutes(t, wh, "team", internal.Route{Page: "123", To: "alice"})
+	wh.UpdateConfig("alice", func(c *internal.WebhookConfig) error {
+		c.AllowFrom = []string{"192.0.2.0/24"}
+		return nil
+	})
+	wh.Subscribe("team")
+	wh.Subscribe("alice")
+
+	denied := func(wid string, allowFrom []string) bool { return false }
+	if err := wh.ForwardFrom("team", nil, pageEvent, denied); err == nil {
+		t.Errorf("Delivery should be dropped rather than reach other subscribers")
+	}
+	if len(b.to) != 0 {
+		t.Errorf("Downstream allowlist should apply to routed deliveries, got %v", b.to)
+	}
+	if st := wh.Status("alice"); st.Errors[internal.ErrorDenied] != 1 {
+		t.Errorf("Denied routed deliveries should be counted, got %v", st.Errors)
+	}
+}
+
+func TestRouteValidate(t *testing.T) {
+	for _, r := range []internal.Route{
+		{Group: "alice"},
+		{Page: "123"},
+		{Page: "123", To: "abc123"},
+	} {
+		if r.Validate("abc123") == nil {
+			t.Errorf("Route %+v should be invalid", r)
+		}
+	}
+	if err := (internal.Route{Page: "123", To: "alice"}).Validate("abc123"); err != nil {
+		t.Errorf("Route should be valid, got %s", err)
+	}
+}
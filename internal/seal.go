@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// SealInfo binds the derived keys to this scheme.
+const SealInfo = "fbwhs webhook v1"
+
+var ErrInvalidKey = errors.New("Key must be a base64url encoded X25519 public key")
+
+// Sealed is a Webhook envelope encrypted to a subscriber: an ephemeral
+// X25519 key agreement, HKDF-SHA256 and AES-256-GCM, with the delivery ID as
+// additional data. Only the subscriber holding the private key can open it.
+type Sealed struct {
+	EphemeralKey string `json:"epk"`
+	Nonce        string `json:"nonce"`
+	Ciphertext   string `json:"ciphertext"`
+}
+
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	key, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+func EncodePublicKey(key *ecdh.PublicKey) string {
+	return base64.RawURLEncoding.EncodeToString(key.Bytes())
+}
+
+// Seal encrypts the header and body of w to key, leaving only its ID in the
+// clear.
+func Seal(key *ecdh.PublicKey, w Webhook) (Webhook, error) {
+	plaintext, err := json.Marshal(Webhook{Header: w.Header, Body: w.Body})
+	if err != nil {
+		return Webhook{}, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Webhook{}, err
+	}
+	aead, err := sealCipher(ephemeral, key, ephemeral.PublicKey(), key)
+	if err != nil {
+		return Webhook{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Webhook{}, err
+	}
+
+	return Webhook{ID: w.ID, Sealed: &Sealed{
+		EphemeralKey: EncodePublicKey(ephemeral.PublicKey()),
+		Nonce:        base64.RawURLEncoding.EncodeToString(nonce),
+		Ciphertext:   base64.RawURLEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, []byte(w.ID))),
+	}}, nil
+}
+
+// Open decrypts a sealed Webhook with the private key of the subscriber.
+func Open(key *ecdh.PrivateKey, w Webhook) (Webhook, error) {
+	if w.Sealed == nil {
+		return w, nil
+	}
+	ephemeral, err := ParsePublicKey(w.Sealed.EphemeralKey)
+	if err != nil {
+		return Webhook{}, err
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(w.Sealed.Nonce)
+	if err != nil {
+		return Webhook{}, err
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(w.Sealed.Ciphertext)
+	if err != nil {
+		return Webhook{}, err
+	}
+	aead, err := sealCipher(key, ephemeral, ephemeral, key.PublicKey())
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return Webhook{}, errors.New("Invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(w.ID))
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	var opened Webhook
+	if err := json.Unmarshal(plaintext, &opened); err != nil {
+		return Webhook{}, err
+	}
+	opened.ID = w.ID
+	return opened, nil
+}
+
+// sealCipher derives the AES-GCM key shared by priv and peer, salted with
+// both public keys.
+func sealCipher(priv *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	block, err := aes.NewCipher(hkdfSHA256(shared, salt, []byte(SealInfo)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdfSHA256 derives a 32 byte key as per RFC 5869, which only needs the
+// first block of the expand step.
+func hkdfSHA256(secret, salt, info []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
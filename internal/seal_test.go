@@ -0,0 +1,68 @@
+package internal_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	"fbwhs/internal"
+)
+
+func TestSeal(t *testing.T) {
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	w := internal.Webhook{ID: "abc", Header: http.Header{"X-Hub-Signature": []string{"sha1=abc"}}, Body: "a body"}
+
+	sealed, err := internal.Seal(key.PublicKey(), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed.ID != "abc" || sealed.Body != "" || sealed.Header != nil || strings.Contains(sealed.Sealed.Ciphertext, "a body") {
+		t.Errorf("Only the ID should be left in the clear, got %+v", sealed)
+	}
+
+	opened, err := internal.Open(key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.ID != "abc" || opened.Body != "a body" || opened.Header.Get("X-Hub-Signature") != "sha1=abc" {
+		t.Errorf("Opened webhook should match, got %+v", opened)
+	}
+
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	if _, err := internal.Open(other, sealed); err == nil {
+		t.Errorf("Other keys should not open the webhook")
+	}
+	sealed.ID = "def"
+	if _, err := internal.Open(key, sealed); err == nil {
+		t.Errorf("Webhooks should be bound to their ID")
+	}
+}
+
+func TestDeliverSealed(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	eventID, _ := wh.SubscribeWith("abc123", internal.Subscription{Key: key.PublicKey()})
+	if err := wh.Forward("abc123", nil, "a body"); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(b.events[0].String(), "a body") {
+		t.Errorf("Delivery should be sealed")
+	}
+	if _, ok := lastDelivery(wh, b, "abc123"); ok {
+		t.Errorf("Sealed deliveries should not be retained")
+	}
+
+	// Deliveries stay unretained while the keyed subscriber reconnects.
+	wh.Unsubscribe(eventID)
+	wh.Subscribe("abc123")
+	if err := wh.Forward("abc123", nil, "another body"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lastDelivery(wh, b, "abc123"); ok {
+		t.Errorf("Deliveries of sealed webhooks should not be retained")
+	}
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"sort"
+	"time"
+)
+
+// Error kinds counted in Status.Errors.
+const (
+	ErrorNoSubscriber = "no_subscriber"
+	ErrorDenied       = "denied"
+	ErrorRejected     = "rejected"
+	ErrorPush         = "push"
+)
+
+type (
+	// Status describes the activity of a webhook as seen by this node.
+	Status struct {
+		Subscribers int         `json:"subscribers"`
+		ConnectedAt []time.Time `json:"connected_at"`
+		Deliveries  int64       `json:"deliveries"`
+		// LastDelivery is nil until the webhook received a delivery.
+		LastDelivery *time.Time `json:"last_delivery,omitempty"`
+		// Verification is the last handshake with the provider, if any.
+		Verification *Verification `json:"verification,omitempty"`
+		// Queued counts the pushes to targets waiting to be retried.
+		Queued int              `json:"queued"`
+		Errors map[string]int64 `json:"errors"`
+	}
+
+	Verification struct {
+		OK bool      `json:"ok"`
+		At time.Time `json:"at"`
+	}
+
+	webhookStats struct {
+		deliveries   int64
+		lastDelivery time.Time
+		verification *Verification
+		errors       map[string]int64
+	}
+)
+
+// RecordVerification records the result of a handshake with the provider.
+func (wh *WebhookHandler) RecordVerification(webhookID string, ok bool) {
+	wh.Lock()
+	defer wh.Unlock()
+	wh.touch(webhookID).stats.verification = &Verification{OK: ok, At: time.Now()}
+}
+
+// CountError counts a failed delivery, e.g. ErrorDenied.
+func (wh *WebhookHandler) CountError(webhookID, kind string) {
+	wh.Lock()
+	defer wh.Unlock()
+	s := &wh.touch(webhookID).stats
+	if s.errors == nil {
+		s.errors = make(map[string]int64)
+	}
+	s.errors[kind]++
+}
+
+func (wh *WebhookHandler) countDelivery(webhookID string) {
+	wh.Lock()
+	defer wh.Unlock()
+	s := &wh.touch(webhookID).stats
+	s.deliveries++
+	s.lastDelivery = time.Now()
+}
+
+// Status returns the subscribers and the delivery statistics of a webhook,
+// which are dropped along with the webhook when it expires.
+func (wh *WebhookHandler) Status(webhookID string) Status {
+	queued, pushErrors := wh.Pusher().Counts(webhookID)
+	st := Status{ConnectedAt: []time.Time{}, Queued: queued, Errors: make(map[string]int64)}
+	if pushErrors > 0 {
+		st.Errors[ErrorPush] = pushErrors
+	}
+
+	wh.Lock()
+	defer wh.Unlock()
+	w, ok := wh.subscriptions[webhookID]
+	if !ok {
+		return st
+	}
+	st.Subscribers = len(w.subscribers)
+	for _, s := range w.subscribers {
+		st.ConnectedAt = append(st.ConnectedAt, s.connectedAt)
+	}
+	sort.Slice(st.ConnectedAt, func(i, j int) bool { return st.ConnectedAt[i].Before(st.ConnectedAt[j]) })
+	st.Deliveries = w.stats.deliveries
+	if !w.stats.lastDelivery.IsZero() {
+		last := w.stats.lastDelivery
+		st.LastDelivery = &last
+	}
+	if v := w.stats.verification; v != nil {
+		verification := *v
+		st.Verification = &verification
+	}
+	for kind, n := range w.stats.errors {
+		st.Errors[kind] = n
+	}
+	return st
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"crypto/ecdh"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -14,23 +15,66 @@ import (
 )
 
 const (
-	PingDelay          = 30 * time.Second
-	SSEBrokerTimeout   = 10 * time.Second
-	SSEBrokerTolerance = 3
-	TheOHSHITLimit     = 500
+	PingDelay           = 30 * time.Second
+	ConnectPingDelay    = 10 * time.Millisecond
+	ConnectPingAttempts = 100
+	SSEBrokerTimeout    = 10 * time.Second
+	SSEBrokerTolerance  = 3
+	TheOHSHITLimit      = 500
 )
 
 type (
 	Webhook struct {
+		ID     string      `json:"id,omitempty"`
 		Header http.Header `json:"header"`
 		Body   string      `json:"body"`
+		// Sealed replaces the header and body for subscribers with a key.
+		Sealed *Sealed `json:"sealed,omitempty"`
+		// WebhookID tags the deliveries of streams subscribed to several
+		// webhooks with the name each webhook was subscribed under.
+		WebhookID string `json:"webhook_id,omitempty"`
+	}
+
+	// Subscription describes what a subscriber receives.
+	Subscription struct {
+		// Group receives the deliveries routed to it. The empty group
+		// receives whatever no route matched.
+		Group string
+		// Key, if set, seals every delivery to the subscriber, and its
+		// deliveries are not retained.
+		Key *ecdh.PublicKey
+		// Filter, if set, only lets the deliveries it matches through.
+		Filter *Filter
 	}
 
 	WebhookHandler struct {
 		sync.Mutex
-		subscriptions map[string][]string
-		eventIDLookup map[string]string
+		subscriptions map[string]*webhook
+		eventIDLookup map[string][]string
+		broadcasts    map[string]*sync.Mutex
 		sseBroker     broker.Broker
+		deliveries    DeliveryStore
+		configStore   ConfigStore
+		bus           Bus
+		pusher        *Pusher
+		expiration    Expiration
+		configs       []configRegistration
+	}
+
+	webhook struct {
+		subscribers   []subscriber
+		lastActivity  time.Time
+		configExpired bool
+		stats         webhookStats
+	}
+
+	subscriber struct {
+		eventID     string
+		group       string
+		key         *ecdh.PublicKey
+		filter      *Filter
+		tag         string
+		connectedAt time.Time
 	}
 )
 
@@ -42,14 +86,107 @@ func NewDefaultWebhookHandler() *WebhookHandler {
 }
 
 func NewWebhookHandler(b broker.Broker) *WebhookHandler {
-	return &WebhookHandler{
-		subscriptions: make(map[string][]string),
-		eventIDLookup: make(map[string]string),
+	wh := &WebhookHandler{
+		subscriptions: make(map[string]*webhook),
+		eventIDLookup: make(map[string][]string),
+		broadcasts:    make(map[string]*sync.Mutex),
 		sseBroker:     b,
+		deliveries:    NewMemDeliveryStore(DeliveryRetention),
+		configStore:   NewMemConfigStore(),
+		expiration:    DefaultExpiration,
+	}
+	wh.SetBus(NewMemBus())
+	wh.SetPusher(NewPusher(DefaultPushOptions))
+	wh.RegisterConfig("config", wh.forgetConfig)
+	wh.RegisterConfig("push log", func(webhookID string) bool {
+		return wh.Pusher().Forget(webhookID)
+	})
+	return wh
+}
+
+// SetBus joins the handler to a bus shared with other nodes.
+func (wh *WebhookHandler) SetBus(b Bus) {
+	wh.Lock()
+	defer wh.Unlock()
+	b.Handle(wh.Deliver)
+	wh.bus = b
+}
+
+// SetPusher replaces the pusher delivering to the push targets of webhooks.
+func (wh *WebhookHandler) SetPusher(p *Pusher) {
+	wh.Lock()
+	defer wh.Unlock()
+	wh.pusher = p
+}
+
+// Pusher returns the pusher, which holds the push logs of webhooks.
+func (wh *WebhookHandler) Pusher() *Pusher {
+	wh.Lock()
+	defer wh.Unlock()
+	return wh.pusher
+}
+
+func (wh *WebhookHandler) SetDeliveryStore(s DeliveryStore) {
+	wh.Lock()
+	defer wh.Unlock()
+	wh.deliveries = s
+}
+
+func (wh *WebhookHandler) SetConfigStore(s ConfigStore) {
+	wh.Lock()
+	defer wh.Unlock()
+	wh.configStore = s
+}
+
+func (wh *WebhookHandler) Config(webhookID string) (WebhookConfig, bool) {
+	return wh.configStore.Get(webhookID)
+}
+
+// UpdateConfig atomically modifies the config of a webhook, creating it if
+// needed.
+func (wh *WebhookHandler) UpdateConfig(webhookID string, fn func(c *WebhookConfig) error) (WebhookConfig, error) {
+	wh.Touch(webhookID)
+	return wh.configStore.Update(webhookID, fn)
+}
+
+// touch records activity on a webhook, creating it if needed. The caller must
+// hold the lock.
+func (wh *WebhookHandler) touch(webhookID string) *webhook {
+	w, ok := wh.subscriptions[webhookID]
+	if !ok {
+		w = &webhook{}
+		wh.subscriptions[webhookID] = w
 	}
+	w.lastActivity = time.Now()
+	w.configExpired = false
+	return w
+}
+
+func (wh *WebhookHandler) Touch(webhookID string) {
+	wh.Lock()
+	defer wh.Unlock()
+	wh.touch(webhookID)
 }
 
 func (wh *WebhookHandler) Subscribe(webhookID string) (string, error) {
+	return wh.SubscribeWith(webhookID, Subscription{})
+}
+
+func (wh *WebhookHandler) SubscribeWith(webhookID string, s Subscription) (string, error) {
+	return wh.subscribe([]string{webhookID}, nil, s)
+}
+
+// SubscribeStream subscribes one event ID to several webhooks. Deliveries
+// are tagged with the name at the same index as their webhook ID, e.g. the
+// alias it was subscribed under.
+func (wh *WebhookHandler) SubscribeStream(webhookIDs, names []string, s Subscription) (string, error) {
+	if len(webhookIDs) == 0 || len(names) != len(webhookIDs) {
+		return "", fmt.Errorf("Every webhook of a stream needs a name")
+	}
+	return wh.subscribe(webhookIDs, names, s)
+}
+
+func (wh *WebhookHandler) subscribe(webhookIDs, names []string, s Subscription) (string, error) {
 	wh.Lock()
 	defer wh.Unlock()
 	if len(wh.eventIDLookup) >= TheOHSHITLimit {
@@ -58,68 +195,223 @@ func (wh *WebhookHandler) Subscribe(webhookID string) (string, error) {
 	}
 
 	eventID := ksuid.New().String()
-	eventIDs := wh.subscriptions[webhookID]
-	eventIDs = append(eventIDs, eventID)
-	wh.subscriptions[webhookID] = eventIDs
-	wh.eventIDLookup[eventID] = webhookID
+	var wids []string
+	for i, webhookID := range webhookIDs {
+		if contains(wids, webhookID) {
+			continue
+		}
+		sub := subscriber{
+			eventID:     eventID,
+			group:       s.Group,
+			key:         s.Key,
+			filter:      s.Filter,
+			connectedAt: time.Now(),
+		}
+		if names != nil {
+			sub.tag = names[i]
+		}
+		w := wh.touch(webhookID)
+		w.subscribers = append(w.subscribers, sub)
+		wids = append(wids, webhookID)
+		if s.Key != nil {
+			// Sealing sticks to the webhook, so that deliveries are not
+			// retained while the subscriber reconnects either.
+			wh.configStore.Update(webhookID, func(c *WebhookConfig) error {
+				c.Sealed = true
+				return nil
+			})
+		}
+	}
+	wh.eventIDLookup[eventID] = wids
+	wh.broadcasts[eventID] = &sync.Mutex{}
 	return eventID, nil
 }
 
 func (wh *WebhookHandler) Unsubscribe(eventID string) bool {
 	wh.Lock()
 	defer wh.Unlock()
-	wid, ok := wh.eventIDLookup[eventID]
+	wids, ok := wh.eventIDLookup[eventID]
 	if !ok {
 		return false
 	}
 
 	delete(wh.eventIDLookup, eventID)
-	eventIDs := wh.subscriptions[wid]
-	for i, id := range eventIDs {
-		if eventID == id {
-			eventIDs = append(eventIDs[:i], eventIDs[i+1:]...)
-			wh.subscriptions[wid] = eventIDs
-			break
+	delete(wh.broadcasts, eventID)
+	for _, wid := range wids {
+		w := wh.touch(wid)
+		for i, s := range w.subscribers {
+			if eventID == s.eventID {
+				w.subscribers = append(w.subscribers[:i], w.subscribers[i+1:]...)
+				break
+			}
 		}
 	}
 	return true
 }
 
 func (wh *WebhookHandler) EventIDs(webhookID string) []string {
-	return wh.subscriptions[webhookID]
+	wh.Lock()
+	defer wh.Unlock()
+	w, ok := wh.subscriptions[webhookID]
+	if !ok {
+		return nil
+	}
+	eventIDs := make([]string, len(w.subscribers))
+	for i, s := range w.subscribers {
+		eventIDs[i] = s.eventID
+	}
+	return eventIDs
+}
+
+func (wh *WebhookHandler) groupSubscribers(webhookID string, groups []string) []subscriber {
+	wh.Lock()
+	defer wh.Unlock()
+	w, ok := wh.subscriptions[webhookID]
+	if !ok {
+		return nil
+	}
+	var subscribers []subscriber
+	for _, s := range w.subscribers {
+		if inGroups(s.group, groups) {
+			subscribers = append(subscribers, s)
+		}
+	}
+	return subscribers
 }
 
+// Forward routes a delivery, pushes the result to the matching targets and
+// publishes it through the bus, so that it reaches the subscribers connected
+// to any node.
 func (wh *WebhookHandler) Forward(webhookID string, header http.Header, body string) error {
-	eventIDs := wh.EventIDs(webhookID)
-	if len(eventIDs) == 0 {
+	return wh.ForwardFrom(webhookID, header, body, nil)
+}
+
+// ForwardFrom forwards a delivery like Forward, only routing it to the
+// downstream webhooks whose allowlist lets its sender through. The allowlist
+// of webhookID itself is left to the caller.
+func (wh *WebhookHandler) ForwardFrom(webhookID string, header http.Header, body string, allow AllowFunc) error {
+	wh.countDelivery(webhookID)
+	n := 0
+	pusher := wh.Pusher()
+	for _, d := range wh.route(NewDelivery(webhookID, header, body), allow) {
+		c, _ := wh.Config(d.WebhookID)
+		for _, t := range c.Targets {
+			if inGroups(t.Group, d.Groups) {
+				pusher.Push(d, t)
+				n++
+			}
+		}
+		delivered, err := wh.bus.Publish(d)
+		if err != nil {
+			return err
+		}
+		n += delivered
+	}
+	if n == 0 {
+		wh.CountError(webhookID, ErrorNoSubscriber)
 		return fmt.Errorf("No webhook connected")
 	}
-	w := Webhook{Header: header, Body: body}
-	b, err := json.Marshal(w)
+	return nil
+}
+
+// Deliver retains a delivery and broadcasts it to the subscribers connected
+// to this node, returning how many there are. Deliveries to subscribers with
+// a key are sealed, and those of webhooks that ever had such a subscriber are
+// not retained, so that no plaintext outlives them.
+// Subscribers whose filter rejects the delivery still count, so that the
+// sender is not told that nobody is listening.
+func (wh *WebhookHandler) Deliver(d Delivery) int {
+	wh.Touch(d.WebhookID)
+	subscribers := wh.groupSubscribers(d.WebhookID, d.Groups)
+	if c, _ := wh.Config(d.WebhookID); !c.Sealed {
+		if err := wh.deliveries.Save(d); err != nil {
+			log.Printf("Unable to save delivery %s: %s", d.ID, err.Error())
+		}
+	}
+	if len(subscribers) == 0 {
+		return 0
+	}
+
+	plain, err := json.Marshal(d.Webhook())
 	if err != nil {
-		return fmt.Errorf("Unable to encode webhook to json")
+		log.Printf("Unable to encode webhook to json: %s", err.Error())
+		return 0
+	}
+	n := 0
+	doc := &document{body: d.Body}
+	for _, s := range subscribers {
+		if !s.filter.match(d.Header, doc) {
+			n++
+			continue
+		}
+		b := plain
+		if s.key != nil || s.tag != "" {
+			w := d.Webhook()
+			var err error
+			if s.key != nil {
+				w, err = Seal(s.key, w)
+			}
+			w.WebhookID = s.tag
+			if err == nil {
+				b, err = json.Marshal(w)
+			}
+			if err != nil {
+				log.Printf("Unable to encode delivery %s: %s", d.ID, err.Error())
+				continue
+			}
+		}
+		wh.broadcast(s.eventID, "webhook", b)
+		n++
 	}
+	return n
+}
 
-	for _, eventID := range eventIDs {
-		wh.sseBroker.BroadcastTo(eventID, sse.NewEvent("webhook", b))
+func (wh *WebhookHandler) Delivery(webhookID, deliveryID string) (Delivery, bool) {
+	return wh.deliveries.Get(webhookID, deliveryID)
+}
+
+func (wh *WebhookHandler) Replay(webhookID, deliveryID string) error {
+	d, ok := wh.Delivery(webhookID, deliveryID)
+	if !ok {
+		return ErrDeliveryNotFound
 	}
-	return nil
+	header := d.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(ReplayHeader, d.ID)
+	return wh.Forward(webhookID, header, d.Body)
+}
+
+// broadcast sends an event to a subscriber, one event at a time, since
+// deliveries and pings are sent from different goroutines.
+func (wh *WebhookHandler) broadcast(eventID, name string, data []byte) error {
+	wh.Lock()
+	mu, ok := wh.broadcasts[eventID]
+	wh.Unlock()
+	if !ok {
+		return fmt.Errorf("Not subscribed: %s", eventID)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return wh.sseBroker.BroadcastTo(eventID, sse.NewEvent(name, data))
 }
 
 func (wh *WebhookHandler) KeepAlive(eventID string) {
 	for {
 		time.Sleep(PingDelay)
-		err := wh.sseBroker.BroadcastTo(
-			eventID,
-			sse.NewEvent("ping", []byte("ping")),
-		)
+		err := wh.broadcast(eventID, "ping", []byte("ping"))
 		if err != nil {
-			wid := wh.eventIDLookup[eventID]
+			wh.Lock()
+			wids := wh.eventIDLookup[eventID]
+			wh.Unlock()
 			wh.Unsubscribe(eventID)
-			log.Printf(
-				"Disconnected, eventID: %s, %d consumer(s) left on webhook: %s",
-				eventID, len(wh.subscriptions[wid]), wid,
-			)
+			for _, wid := range wids {
+				log.Printf(
+					"Disconnected, eventID: %s, %d consumer(s) left on webhook: %s",
+					eventID, len(wh.EventIDs(wid)), wid,
+				)
+			}
 			break
 		}
 	}
@@ -127,9 +419,29 @@ func (wh *WebhookHandler) KeepAlive(eventID string) {
 
 func (wh *WebhookHandler) HandleEvents(rw http.ResponseWriter, r *http.Request) {
 	eventID := r.URL.Query().Get("id")
-	if _, ok := wh.eventIDLookup[eventID]; !ok {
+	wh.Lock()
+	_, ok := wh.eventIDLookup[eventID]
+	wh.Unlock()
+	if !ok {
 		rw.WriteHeader(http.StatusBadRequest)
 		return
 	}
+
+	// The broker only responds with the first event, ping right away so that
+	// subscribers know as soon as they can receive webhooks.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < ConnectPingAttempts; i++ {
+			if wh.broadcast(eventID, "ping", []byte("ping")) == nil {
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(ConnectPingDelay):
+			}
+		}
+	}()
 	wh.sseBroker.ClientHandler(rw, r)
+	close(done)
 }
@@ -3,6 +3,7 @@ package internal_test
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 
 	"fbwhs/internal"
@@ -12,6 +13,7 @@ import (
 
 type inMemBroker struct {
 	events []*event.Event
+	to     []string
 }
 
 func (b *inMemBroker) Broadcast(evt *event.Event) error {
@@ -21,6 +23,7 @@ func (b *inMemBroker) Broadcast(evt *event.Event) error {
 
 func (b *inMemBroker) BroadcastTo(id string, evt *event.Event) error {
 	b.events = append(b.events, evt)
+	b.to = append(b.to, id)
 	return nil
 }
 
@@ -92,7 +95,11 @@ func TestForward(t *testing.T) {
 		t.Fail()
 	}
 
-	w := internal.Webhook{Header: nil, Body: "a body"}
+	d, ok := lastDelivery(wh, b, wid)
+	if !ok {
+		t.Fatalf("Delivery should be retained")
+	}
+	w := internal.Webhook{ID: d.ID, Header: nil, Body: "a body"}
 	jsonBody, _ := json.Marshal(w)
 	event := sse.NewEvent("webhook", jsonBody)
 	eventData := b.events[0].String()
@@ -100,3 +107,116 @@ func TestForward(t *testing.T) {
 		t.Errorf("Event data should match")
 	}
 }
+
+func TestReplay(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	wid := "abc123"
+	wh.Subscribe(wid)
+	header := http.Header{"X-Hub-Signature": []string{"sha1=abc"}}
+	wh.Forward(wid, header, "a body")
+	d, _ := lastDelivery(wh, b, wid)
+
+	if err := wh.Replay(wid, d.ID); err != nil {
+		t.Fatalf("Replay should succeed, got %s", err)
+	}
+	if len(b.events) != 2 {
+		t.Fatalf("Replay should broadcast again")
+	}
+
+	replayed, _ := lastDelivery(wh, b, wid)
+	if replayed.Body != "a body" || replayed.Header.Get("X-Hub-Signature") != "sha1=abc" {
+		t.Errorf("Replay should keep the original payload")
+	}
+	if replayed.Header.Get(internal.ReplayHeader) != d.ID {
+		t.Errorf("Replay should be marked with the original delivery ID")
+	}
+	if header.Get(internal.ReplayHeader) != "" {
+		t.Errorf("Replay should not modify the stored delivery")
+	}
+}
+
+func TestReplayNotFound(t *testing.T) {
+	wh := internal.NewWebhookHandler(&inMemBroker{})
+	if wh.Replay("abc123", "foo") != internal.ErrDeliveryNotFound {
+		t.Errorf("Should return ErrDeliveryNotFound")
+	}
+	if wh.Replay("abc123", "") != internal.ErrDeliveryNotFound {
+		t.Errorf("Should return ErrDeliveryNotFound")
+	}
+}
+
+func TestDeliveryRetention(t *testing.T) {
+	s := internal.NewMemDeliveryStore(2)
+	first := internal.NewDelivery("abc123", nil, "1")
+	s.Save(first)
+	s.Save(internal.NewDelivery("abc123", nil, "2"))
+	last := internal.NewDelivery("abc123", nil, "3")
+	s.Save(last)
+
+	if _, ok := s.Get("abc123", first.ID); ok {
+		t.Errorf("Oldest delivery should be evicted")
+	}
+	if _, ok := s.Get("abc123", last.ID); !ok {
+		t.Errorf("Latest delivery should be retained")
+	}
+	if _, ok := s.Get("foo", last.ID); ok {
+		t.Errorf("Deliveries should be scoped by webhook")
+	}
+}
+
+func lastDelivery(wh *internal.WebhookHandler, b *inMemBroker, wid string) (internal.Delivery, bool) {
+	if len(b.events) == 0 {
+		return internal.Delivery{}, false
+	}
+	evt := b.events[len(b.events)-1].String()
+	data := strings.TrimSuffix(strings.TrimPrefix(evt, "event:webhook\ndata:"), "\n\n")
+	var w internal.Webhook
+	if err := json.Unmarshal([]byte(data), &w); err != nil {
+		return internal.Delivery{}, false
+	}
+	return wh.Delivery(wid, w.ID)
+}
+
+func TestSubscribeStream(t *testing.T) {
+	b := &inMemBroker{}
+	wh := internal.NewWebhookHandler(b)
+	eventID, err := wh.SubscribeStream([]string{"abc123", "def456"}, []string{"abc123", "team"}, internal.Subscription{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wh.Deliver(internal.NewDelivery("def456", nil, "test=123"))
+	if len(b.events) != 1 || !strings.Contains(b.events[0].String(), `"webhook_id":"team"`) {
+		t.Errorf("Deliveries should be tagged with the webhook name, got %v", b.events)
+	}
+
+	wh.Unsubscribe(eventID)
+	if len(wh.EventIDs("abc123")) != 0 || len(wh.EventIDs("def456")) != 0 {
+		t.Errorf("Unsubscribing should leave every webhook of the stream")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	wh := internal.NewWebhookHandler(&inMemBroker{})
+	if st := wh.Status("abc123"); st.Subscribers != 0 || st.LastDelivery != nil || st.Verification != nil {
+		t.Errorf("Unknown webhooks should have an empty status, got %+v", st)
+	}
+
+	wh.RecordVerification("abc123", false)
+	wh.Forward("abc123", nil, "test=123")
+	wh.Subscribe("abc123")
+	wh.Forward("abc123", nil, "test=456")
+	wh.CountError("abc123", internal.ErrorDenied)
+
+	st := wh.Status("abc123")
+	if st.Subscribers != 1 || len(st.ConnectedAt) != 1 || st.Deliveries != 2 || st.LastDelivery == nil {
+		t.Errorf("Subscribers and deliveries should be counted, got %+v", st)
+	}
+	if st.Verification == nil || st.Verification.OK {
+		t.Errorf("Failed verification should be recorded, got %+v", st.Verification)
+	}
+	if st.Errors[internal.ErrorNoSubscriber] != 1 || st.Errors[internal.ErrorDenied] != 1 {
+		t.Errorf("Errors should be counted by kind, got %v", st.Errors)
+	}
+}
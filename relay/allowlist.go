@@ -0,0 +1,59 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"fbwhs/internal"
+	"gopkg.in/macaron.v1"
+)
+
+func handleAllowlistGet(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	c, _ := wh.Config(wh.Resolve(ctx.Params(":wid")))
+	entries := c.AllowFrom
+	if entries == nil {
+		entries = []string{}
+	}
+	ctx.JSON(http.StatusOK, entries)
+}
+
+func handleAllowlistPut(ctx *macaron.Context, wh *internal.WebhookHandler, l *internal.Limiter, a *internal.Allowlist) {
+	wid := wh.Resolve(ctx.Params(":wid"))
+	body, err := l.ReadBody(ctx.Req.Request)
+	if err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	var entries []string
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	if err := a.Validate(entries); err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	c, err := wh.UpdateConfig(wid, func(c *WebhookConfig) error {
+		c.AllowFrom = entries
+		return nil
+	})
+	if err != nil {
+		ctx.PlainText(http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, c.AllowFrom)
+}
+
+func handleAllowlistDelete(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	_, err := wh.UpdateConfig(wh.Resolve(ctx.Params(":wid")), func(c *WebhookConfig) error {
+		c.AllowFrom = nil
+		return nil
+	})
+	if err != nil {
+		ctx.PlainText(http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
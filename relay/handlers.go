@@ -0,0 +1,161 @@
+package relay
+
+import (
+	"log"
+	"net/http"
+
+	"fbwhs/internal"
+	"gopkg.in/macaron.v1"
+)
+
+func handleWebhookConnect(ctx *macaron.Context, r *Relay, wh *internal.WebhookHandler) {
+	wid := ctx.Params(":wid")
+	for _, p := range r.providers {
+		if p.Handshake(ctx.Resp, ctx.Req.Request, wid) {
+			wh.RecordVerification(wh.Resolve(wid), ctx.Resp.Status() == http.StatusOK)
+			return
+		}
+	}
+
+	if r.authorize(ctx); ctx.Written() {
+		return
+	}
+
+	sub, err := subscription(ctx)
+	if err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	eventID, err := wh.SubscribeWith(wh.Resolve(wid), sub)
+	if err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	go wh.KeepAlive(eventID)
+	// A relative redirect keeps working when the relay is mounted under a
+	// path prefix.
+	ctx.Resp.Header().Set("Location", "../events?id="+eventID)
+	ctx.Status(http.StatusFound)
+}
+
+// handleStream subscribes to every webhook given as a wid query parameter
+// over one SSE connection, tagging deliveries with their wid. Each webhook
+// must be allowed, and claimed ones owned by one of the secrets sent.
+func handleStream(ctx *macaron.Context, r *Relay, wh *internal.WebhookHandler) {
+	names := ctx.QueryStrings("wid")
+	if len(names) == 0 {
+		ctx.PlainText(http.StatusBadRequest, []byte("Missing wid"))
+		return
+	}
+	secrets := requestSecrets(ctx)
+	wids := make([]string, len(names))
+	for i, name := range names {
+		if !r.allowed(ctx.Req.Request, name, secrets...) {
+			ctx.PlainText(http.StatusUnauthorized, []byte(http.StatusText(http.StatusUnauthorized)+": "+name))
+			return
+		}
+		wids[i] = wh.Resolve(name)
+	}
+
+	sub, err := subscription(ctx)
+	if err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	eventID, err := wh.SubscribeStream(wids, names, sub)
+	if err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	go wh.KeepAlive(eventID)
+	ctx.Resp.Header().Set("Location", "events?id="+eventID)
+	ctx.Status(http.StatusFound)
+}
+
+// requestSecrets returns the owner secrets sent with a request, as headers or
+// query parameters, for requests on several webhooks.
+func requestSecrets(ctx *macaron.Context) []string {
+	return append(append([]string(nil), ctx.Req.Header.Values(SecretHeader)...), ctx.QueryStrings("secret")...)
+}
+
+// subscription reads the group, key and filter query parameters.
+func subscription(ctx *macaron.Context) (internal.Subscription, error) {
+	sub := internal.Subscription{Group: ctx.Query("group")}
+	var err error
+	if key := ctx.Query("key"); key != "" {
+		if sub.Key, err = internal.ParsePublicKey(key); err != nil {
+			return sub, err
+		}
+	}
+	if filters := ctx.QueryStrings("filter"); len(filters) > 0 {
+		if sub.Filter, err = internal.ParseFilter(filters...); err != nil {
+			return sub, err
+		}
+	}
+	return sub, nil
+}
+
+func handleWebhookForward(ctx *macaron.Context, wh *internal.WebhookHandler, l *internal.Limiter, a *internal.Allowlist) {
+	wid := wh.Resolve(ctx.Params(":wid"))
+	if c, _ := wh.Config(wid); len(c.AllowFrom) > 0 && !a.Allow(wid, c.AllowFrom, ctx.Req.Request) {
+		wh.CountError(wid, internal.ErrorDenied)
+		ctx.PlainText(http.StatusForbidden, []byte(http.StatusText(http.StatusForbidden)))
+		return
+	}
+
+	body, err := l.ReadBody(ctx.Req.Request)
+	if err != nil {
+		wh.CountError(wid, internal.ErrorRejected)
+	}
+	switch err {
+	case nil:
+	case internal.ErrBodyTooLarge:
+		ctx.PlainText(http.StatusRequestEntityTooLarge, []byte(err.Error()))
+		return
+	case internal.ErrSlowRequest:
+		ctx.PlainText(http.StatusRequestTimeout, []byte(err.Error()))
+		return
+	default:
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	allow := func(wid string, allowFrom []string) bool {
+		return a.Allow(wid, allowFrom, ctx.Req.Request)
+	}
+	if err := wh.ForwardFrom(wid, ctx.Req.Header, body, allow); err != nil {
+		log.Printf("Forward error: %s", err.Error())
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+func handleStatus(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	ctx.JSON(http.StatusOK, wh.Status(wh.Resolve(ctx.Params(":wid"))))
+}
+
+func handleDeliveryGet(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	d, ok := wh.Delivery(wh.Resolve(ctx.Params(":wid")), ctx.Params(":id"))
+	if !ok {
+		ctx.PlainText(http.StatusNotFound, []byte(internal.ErrDeliveryNotFound.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, d)
+}
+
+func handleDeliveryReplay(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	wid := wh.Resolve(ctx.Params(":wid"))
+	err := wh.Replay(wid, ctx.Params(":id"))
+	if err == internal.ErrDeliveryNotFound {
+		ctx.PlainText(http.StatusNotFound, []byte(err.Error()))
+		return
+	} else if err != nil {
+		log.Printf("Replay error: %s", err.Error())
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
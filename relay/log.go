@@ -0,0 +1,37 @@
+package relay
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+// requestLog logs requests like macaron.Logger, but without the secrets that
+// EventSource subscribers pass in the query.
+func requestLog(ctx *macaron.Context, logger *log.Logger) {
+	start := time.Now()
+	uri := redactedURI(ctx.Req.Request)
+	logger.Printf("%s: Started %s %s for %s", start.Format(macaron.LogTimeFormat), ctx.Req.Method, uri, ctx.RemoteAddr())
+	ctx.Next()
+	status := ctx.Resp.Status()
+	logger.Printf("%s: Completed %s %s %v %s in %v", time.Now().Format(macaron.LogTimeFormat), ctx.Req.Method, uri, status, http.StatusText(status), time.Since(start))
+}
+
+func redactedURI(req *http.Request) string {
+	u, err := url.ParseRequestURI(req.RequestURI)
+	if err != nil {
+		return req.URL.Path
+	}
+	q := u.Query()
+	if _, ok := q["secret"]; !ok {
+		return req.RequestURI
+	}
+	for i := range q["secret"] {
+		q["secret"][i] = "REDACTED"
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
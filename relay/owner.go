@@ -0,0 +1,55 @@
+package relay
+
+import (
+	"net/http"
+
+	"fbwhs/internal"
+	"gopkg.in/macaron.v1"
+)
+
+func handleClaim(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	claimed, err := wh.Claim(ctx.Params(":wid"), ctx.Req.Header.Get(SecretHeader))
+	switch err {
+	case nil:
+	case internal.ErrClaimed, internal.ErrIsAlias:
+		ctx.PlainText(http.StatusConflict, []byte(err.Error()))
+		return
+	default:
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	if claimed {
+		ctx.Status(http.StatusCreated)
+	} else {
+		ctx.Status(http.StatusOK)
+	}
+}
+
+func handleRelease(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	if err := wh.Release(ctx.Params(":wid"), ctx.Req.Header.Get(SecretHeader)); err != nil {
+		ctx.PlainText(http.StatusConflict, []byte(err.Error()))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func handleAliasPut(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	err := wh.SetAlias(ctx.Params(":name"), ctx.Params(":wid"))
+	switch err {
+	case nil:
+		ctx.Status(http.StatusOK)
+	case internal.ErrNameTaken:
+		ctx.PlainText(http.StatusConflict, []byte(err.Error()))
+	default:
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+	}
+}
+
+func handleAliasDelete(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	if err := wh.RemoveAlias(ctx.Params(":name"), ctx.Params(":wid")); err != nil {
+		ctx.PlainText(http.StatusNotFound, []byte(err.Error()))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,48 @@
+package relay
+
+import "net/http"
+
+type (
+	// Provider adapts the relay to a webhook provider. Handshake answers the
+	// provider's handshake requests on GET /webhook/:wid and reports whether
+	// it did; unhandled requests are treated as subscriptions.
+	Provider interface {
+		Handshake(rw http.ResponseWriter, r *http.Request, webhookID string) bool
+	}
+
+	ProviderFunc func(rw http.ResponseWriter, r *http.Request, webhookID string) bool
+)
+
+// Facebook answers Facebook's verification requests, using the webhook ID as
+// the verify token.
+// See https://developers.facebook.com/docs/graph-api/webhooks/getting-started#verification-requests
+var Facebook Provider = ProviderFunc(handleFacebookVerification)
+
+func (f ProviderFunc) Handshake(rw http.ResponseWriter, r *http.Request, webhookID string) bool {
+	return f(rw, r, webhookID)
+}
+
+func handleFacebookVerification(rw http.ResponseWriter, r *http.Request, wid string) bool {
+	query := r.URL.Query()
+	modes := query["hub.mode"]
+	if len(modes) != 1 || modes[0] != "subscribe" {
+		return false
+	}
+
+	challenges := query["hub.challenge"]
+	if len(challenges) != 1 {
+		rw.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+
+	tokens := query["hub.verify_token"]
+	if len(tokens) != 1 || tokens[0] != wid {
+		rw.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte(challenges[0]))
+	return true
+}
@@ -0,0 +1,342 @@
+// Package relay builds the fbwhs webhook relay as a single http.Handler, so
+// that it can be served on its own or mounted inside another server:
+//
+//	r := relay.New(relay.WithLimits(relay.DefaultLimits))
+//	defer r.Close()
+//	http.Handle("/fbwhs/", http.StripPrefix("/fbwhs", r))
+package relay
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"fbwhs/internal"
+	"github.com/davidsbond/sse"
+	"github.com/davidsbond/sse/broker"
+	"gopkg.in/macaron.v1"
+)
+
+type (
+	Limits        = internal.Limits
+	Expiration    = internal.Expiration
+	Delivery      = internal.Delivery
+	DeliveryStore = internal.DeliveryStore
+	ConfigStore   = internal.ConfigStore
+	WebhookConfig = internal.WebhookConfig
+	Route         = internal.Route
+	Target        = internal.Target
+	PushOptions   = internal.PushOptions
+	PushAttempt   = internal.PushAttempt
+	WebhookStatus = internal.Status
+	Verification  = internal.Verification
+	Bus           = internal.Bus
+	MemBus        = internal.MemBus
+	HTTPBus       = internal.HTTPBus
+
+	// Auth decides whether a request may subscribe to, inspect or replay
+	// the deliveries of a webhook. Inbound webhook requests and provider
+	// handshakes are never authenticated.
+	Auth func(r *http.Request, webhookID string) bool
+
+	Option func(*options)
+
+	// Stats counts the inbound requests rejected by the limits and the
+	// decisions of webhook allowlists.
+	Stats struct {
+		Oversized int64
+		Slow      int64
+		Allowed   int64
+		Denied    int64
+	}
+
+	options struct {
+		broker        broker.Broker
+		store         DeliveryStore
+		configStore   ConfigStore
+		bus           Bus
+		auth          Auth
+		limits        Limits
+		expiration    Expiration
+		sweepInterval time.Duration
+		providers     []Provider
+		ipRanges      map[string][]*net.IPNet
+		proxies       []*net.IPNet
+		origins       []string
+		push          *PushOptions
+		logger        bool
+	}
+
+	// Relay serves webhook subscriptions under /webhook/:wid and their SSE
+	// streams under /events.
+	Relay struct {
+		wh          *internal.WebhookHandler
+		limiter     *internal.Limiter
+		allowlist   *internal.Allowlist
+		auth        Auth
+		providers   []Provider
+		origins     []string
+		routes      http.Handler
+		events      http.Handler
+		stopSweeper func()
+	}
+)
+
+const (
+	DefaultSweepInterval = internal.SweepInterval
+	// SecretHeader carries the owner secret of a claimed webhook.
+	SecretHeader = internal.SecretHeader
+	// MaxTargets bounds how many targets a webhook can have.
+	MaxTargets = internal.MaxTargets
+)
+
+var (
+	DefaultLimits      = internal.DefaultLimits
+	DefaultExpiration  = internal.DefaultExpiration
+	DefaultPushOptions = internal.DefaultPushOptions
+)
+
+// ParseCIDRs parses CIDR ranges, where a bare IP stands for itself.
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	return internal.ParseCIDRs(entries)
+}
+
+// LoadCIDRs reads CIDR ranges from a file, one per line, ignoring blank
+// lines and # comments.
+func LoadCIDRs(path string) ([]*net.IPNet, error) {
+	return internal.LoadCIDRs(path)
+}
+
+func NewMemBus() *MemBus {
+	return internal.NewMemBus()
+}
+
+// NewHTTPBus publishes to peers, the base URLs of the other relays, which
+// must share the same secret. The secret cannot be empty.
+func NewHTTPBus(peers []string, secret string) (*HTTPBus, error) {
+	return internal.NewHTTPBus(peers, secret)
+}
+
+func WithBroker(b broker.Broker) Option {
+	return func(o *options) { o.broker = b }
+}
+
+func WithStore(s DeliveryStore) Option {
+	return func(o *options) { o.store = s }
+}
+
+// WithConfigStore keeps the per-webhook config, such as routes, in s. In
+// cluster mode every relay must share the same store.
+func WithConfigStore(s ConfigStore) Option {
+	return func(o *options) { o.configStore = s }
+}
+
+// WithBus joins the relay to a cluster. A bus that is also an http.Handler,
+// such as an HTTPBus, is served under /cluster/deliveries.
+func WithBus(b Bus) Option {
+	return func(o *options) { o.bus = b }
+}
+
+func WithAuth(a Auth) Option {
+	return func(o *options) { o.auth = a }
+}
+
+func WithLimits(l Limits) Option {
+	return func(o *options) { o.limits = l }
+}
+
+func WithExpiration(e Expiration, sweepInterval time.Duration) Option {
+	return func(o *options) {
+		o.expiration = e
+		o.sweepInterval = sweepInterval
+	}
+}
+
+// WithProviders replaces the default Facebook provider.
+func WithProviders(p ...Provider) Option {
+	return func(o *options) { o.providers = p }
+}
+
+// WithIPRanges names a set of ranges, such as Facebook's published ones, so
+// that webhook allowlists can refer to it.
+func WithIPRanges(name string, ranges []*net.IPNet) Option {
+	return func(o *options) {
+		if o.ipRanges == nil {
+			o.ipRanges = make(map[string][]*net.IPNet)
+		}
+		o.ipRanges[name] = ranges
+	}
+}
+
+// WithTrustedProxies honors X-Forwarded-For on requests from these ranges
+// when checking webhook allowlists.
+func WithTrustedProxies(ranges []*net.IPNet) Option {
+	return func(o *options) { o.proxies = ranges }
+}
+
+// WithCORS lets browsers on other origins subscribe to webhooks through
+// EventSource. "*" allows any origin.
+func WithCORS(origins ...string) Option {
+	return func(o *options) { o.origins = origins }
+}
+
+// WithPush tunes how deliveries are pushed to the targets of webhooks,
+// which defaults to DefaultPushOptions.
+func WithPush(p PushOptions) Option {
+	return func(o *options) { o.push = &p }
+}
+
+// WithRequestLog logs every request like macaron.Classic does, with the
+// secrets passed in query strings redacted.
+func WithRequestLog() Option {
+	return func(o *options) { o.logger = true }
+}
+
+func New(opts ...Option) *Relay {
+	o := options{
+		limits:        DefaultLimits,
+		expiration:    DefaultExpiration,
+		sweepInterval: DefaultSweepInterval,
+		providers:     []Provider{Facebook},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.broker == nil {
+		o.broker = sse.NewBroker(sse.Config{
+			Timeout:   internal.SSEBrokerTimeout,
+			Tolerance: internal.SSEBrokerTolerance,
+		})
+	}
+
+	wh := internal.NewWebhookHandler(o.broker)
+	if o.store != nil {
+		wh.SetDeliveryStore(o.store)
+	}
+	if o.configStore != nil {
+		wh.SetConfigStore(o.configStore)
+	}
+	if o.bus != nil {
+		wh.SetBus(o.bus)
+	}
+	if o.push != nil {
+		wh.SetPusher(internal.NewPusher(*o.push))
+	}
+	wh.SetExpiration(o.expiration)
+
+	r := &Relay{
+		wh:          wh,
+		limiter:     internal.NewLimiter(o.limits),
+		allowlist:   internal.NewAllowlist(o.ipRanges, o.proxies),
+		auth:        o.auth,
+		providers:   o.providers,
+		origins:     o.origins,
+		stopSweeper: func() {},
+	}
+	if o.sweepInterval > 0 {
+		r.stopSweeper = wh.StartSweeper(o.sweepInterval)
+	}
+
+	m := macaron.New()
+	if o.logger {
+		m.Use(requestLog)
+	}
+	m.Use(macaron.Recovery())
+	m.Use(macaron.Renderer())
+	m.Map(r)
+	m.Map(wh)
+	m.Map(r.limiter)
+	m.Map(r.allowlist)
+	m.Get("/webhook/:wid", r.allowOrigin, handleWebhookConnect)
+	m.Get("/webhook/:wid/view", handleView)
+	m.Get("/stream", r.allowOrigin, handleStream)
+	m.Post("/webhook/:wid", handleWebhookForward)
+	m.Get("/webhook/:wid/status", r.authorize, handleStatus)
+	m.Get("/webhook/:wid/deliveries/:id", r.authorize, handleDeliveryGet)
+	m.Post("/webhook/:wid/deliveries/:id/replay", r.authorize, handleDeliveryReplay)
+	m.Get("/webhook/:wid/routes", r.authorize, handleRoutesGet)
+	m.Put("/webhook/:wid/routes", r.authorize, handleRoutesPut)
+	m.Delete("/webhook/:wid/routes", r.authorize, handleRoutesDelete)
+	m.Get("/webhook/:wid/allowlist", r.authorize, handleAllowlistGet)
+	m.Put("/webhook/:wid/allowlist", r.authorize, handleAllowlistPut)
+	m.Delete("/webhook/:wid/allowlist", r.authorize, handleAllowlistDelete)
+	m.Get("/webhook/:wid/targets", r.authorize, handleTargetsGet)
+	m.Put("/webhook/:wid/targets", r.authorize, handleTargetsPut)
+	m.Delete("/webhook/:wid/targets", r.authorize, handleTargetsDelete)
+	m.Get("/webhook/:wid/targets/log", r.authorize, handleTargetsLog)
+	m.Post("/webhook/:wid/claim", r.authorize, handleClaim)
+	m.Delete("/webhook/:wid/claim", r.authorize, handleRelease)
+	m.Put("/webhook/:wid/aliases/:name", r.authorize, handleAliasPut)
+	m.Delete("/webhook/:wid/aliases/:name", r.authorize, handleAliasDelete)
+	if h, ok := o.bus.(http.Handler); ok {
+		m.Post(internal.ClusterPath, h.ServeHTTP)
+	}
+
+	r.routes = r.limiter.Handler(m)
+	r.events = r.limiter.Stream(http.HandlerFunc(wh.HandleEvents))
+	return r
+}
+
+func (r *Relay) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/events" {
+		r.allowOrigin(rw, req)
+		r.events.ServeHTTP(rw, req)
+		return
+	}
+	r.routes.ServeHTTP(rw, req)
+}
+
+// Server returns an http.Server for the relay with the connection level
+// limits applied.
+func (r *Relay) Server(addr string) *http.Server {
+	return r.limiter.Server(addr, r)
+}
+
+// Stats counts rejected requests and allowlist decisions since the relay
+// started.
+func (r *Relay) Stats() Stats {
+	var s Stats
+	s.Oversized, s.Slow = r.limiter.Stats()
+	s.Allowed, s.Denied = r.allowlist.Stats()
+	return s
+}
+
+// Close stops the background sweeper and abandons pending push retries.
+func (r *Relay) Close() error {
+	r.stopSweeper()
+	r.wh.Pusher().Close()
+	return nil
+}
+
+// authorize runs the Auth of the relay and, once a webhook is claimed,
+// requires its owner secret.
+func (r *Relay) authorize(ctx *macaron.Context) {
+	wid := ctx.Params(":wid")
+	req := ctx.Req.Request
+	secret := req.Header.Get(SecretHeader)
+	if secret == "" {
+		// EventSource cannot send headers.
+		secret = ctx.Query("secret")
+	}
+	if !r.allowed(req, wid, secret) {
+		ctx.PlainText(http.StatusUnauthorized, []byte(http.StatusText(http.StatusUnauthorized)))
+	}
+}
+
+// allowed runs the Auth of the relay for a webhook and checks that one of
+// the secrets owns it, if it is claimed.
+func (r *Relay) allowed(req *http.Request, wid string, secrets ...string) bool {
+	if r.auth != nil && !r.auth(req, wid) {
+		return false
+	}
+	wid = r.wh.Resolve(wid)
+	if len(secrets) == 0 {
+		return r.wh.IsOwner(wid, "")
+	}
+	for _, secret := range secrets {
+		if r.wh.IsOwner(wid, secret) {
+			return true
+		}
+	}
+	return false
+}
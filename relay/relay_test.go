@@ -0,0 +1,550 @@
+package relay_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"fbwhs/client"
+	"fbwhs/relay"
+)
+
+func TestFacebookVerification(t *testing.T) {
+	r := relay.New()
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	cases := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{"hub.mode=subscribe&hub.challenge=42&hub.verify_token=abc123", http.StatusOK, "42"},
+		{"hub.mode=subscribe&hub.challenge=42&hub.verify_token=foo", http.StatusBadRequest, ""},
+		{"hub.mode=subscribe&hub.verify_token=abc123", http.StatusBadRequest, ""},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(srv.URL + "/webhook/abc123?" + c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || string(body) != c.body {
+			t.Errorf("%s: expected %d %q, got %d %q", c.query, c.status, c.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestMountedRelay(t *testing.T) {
+	r := relay.New()
+	defer r.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/fbwhs/", http.StripPrefix("/fbwhs", r))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := srv.URL + "/fbwhs/webhook/abc123"
+	deliveries, err := client.Subscribe(ctx, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(src, "application/json", strings.NewReader(`{"object":"page"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Forward failed with %d", resp.StatusCode)
+	}
+
+	select {
+	case d := <-deliveries:
+		if d.Body != `{"object":"page"}` {
+			t.Errorf("Unexpected delivery %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Delivery not received")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	r := relay.New(relay.WithAuth(func(req *http.Request, wid string) bool {
+		return req.Header.Get("Authorization") == "Bearer "+wid
+	}))
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx := context.Background()
+	if _, err := client.Subscribe(ctx, srv.URL+"/webhook/abc123", nil); err == nil {
+		t.Errorf("Unauthorized subscriptions should be rejected")
+	}
+	if _, err := client.FetchDelivery(ctx, http.DefaultClient, srv.URL+"/webhook/abc123", "foo"); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("Unauthorized delivery lookups should be rejected, got %v", err)
+	}
+
+	resp, err := http.Get(srv.URL + "/webhook/abc123?hub.mode=subscribe&hub.challenge=42&hub.verify_token=abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Verification requests should not be authenticated, got %d", resp.StatusCode)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	r := relay.New()
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	put := func(body string) int {
+		req, _ := http.NewRequest("PUT", srv.URL+"/webhook/team/routes", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put(`[{"page":"123"}]`); status != http.StatusBadRequest {
+		t.Errorf("Invalid routes should be rejected, got %d", status)
+	}
+	if status := put(`[{"page":"123","group":"alice"}]`); status != http.StatusOK {
+		t.Fatalf("Routes should be stored, got %d", status)
+	}
+	if _, err := client.Claim(context.Background(), nil, srv.URL+"/webhook/bob", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if status := put(`[{"page":"123","to":"bob"},{"page":"123","group":"alice"}]`); status != http.StatusForbidden {
+		t.Errorf("Routes into webhooks owned by someone else should be rejected, got %d", status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := srv.URL + "/webhook/team"
+	alice, err := client.Subscribe(ctx, src+"?group=alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := client.Subscribe(ctx, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(src, "application/json", strings.NewReader(`{"entry":[{"id":"123"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	select {
+	case <-alice:
+	case <-time.After(time.Second):
+		t.Fatalf("Routed delivery not received")
+	}
+	select {
+	case d := <-rest:
+		t.Errorf("Routed delivery should not reach other groups, got %+v", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFilteredSubscription(t *testing.T) {
+	r := relay.New()
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	src := srv.URL + "/webhook/abc123"
+	resp, err := http.Get(src + "?filter=$.entry[")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Invalid filters should be rejected, got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deliveries, err := client.Subscribe(ctx, src, &client.Options{Filter: []string{"$.object=page", "X-App-Id=456"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"object":"instagram"}`, `{"object":"page"}`} {
+		req, _ := http.NewRequest("POST", src, strings.NewReader(body))
+		req.Header.Set("X-App-Id", "456")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Filtered deliveries should be accepted, got %d", resp.StatusCode)
+		}
+	}
+
+	select {
+	case d := <-deliveries:
+		if d.Body != `{"object":"page"}` {
+			t.Errorf("Only the matching delivery should be received, got %s", d.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Matching delivery not received")
+	}
+}
+
+func TestStream(t *testing.T) {
+	r := relay.New()
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := client.Claim(ctx, nil, srv.URL+"/webhook/team-alice", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.StreamURL(srv.URL+"/webhook/team-alice", srv.URL+"/webhook/abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Subscribe(ctx, stream, nil); err == nil {
+		t.Errorf("Streams should be authorized for every webhook")
+	}
+	deliveries, err := client.Subscribe(ctx, stream, &client.Options{
+		Header: http.Header{client.SecretHeader: {"s3cret"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, wid := range []string{"abc123", "team-alice"} {
+		resp, err := http.Post(srv.URL+"/webhook/"+wid, "text/plain", strings.NewReader(wid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		select {
+		case d := <-deliveries:
+			if d.WebhookID != wid || d.Body != wid {
+				t.Errorf("Expected a delivery tagged %s, got %+v", wid, d)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Delivery to %s not received", wid)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	r := relay.New()
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	src := srv.URL + "/webhook/abc123"
+	resp, err := http.Get(src + "?hub.mode=subscribe&hub.challenge=42&hub.verify_token=abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post(src, "text/plain", strings.NewReader("test=123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deliveries, err := client.Subscribe(ctx, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Post(src, "text/plain", strings.NewReader("test=456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	<-deliveries
+
+	st, err := client.FetchStatus(ctx, nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Verification == nil || !st.Verification.OK {
+		t.Errorf("Verification should be recorded, got %+v", st.Verification)
+	}
+	if st.Subscribers != 1 || len(st.ConnectedAt) != 1 {
+		t.Errorf("Subscriber should be listed, got %+v", st)
+	}
+	if st.Deliveries != 2 || st.LastDelivery == nil || st.Errors["no_subscriber"] != 1 {
+		t.Errorf("Deliveries and errors should be counted, got %+v", st)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	r := relay.New()
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx := context.Background()
+	src := srv.URL + "/webhook/team-alice"
+	if claimed, err := client.Claim(ctx, nil, src, "s3cret"); !claimed || err != nil {
+		t.Fatalf("Webhook should be claimed, got %v %v", claimed, err)
+	}
+	if _, err := client.Claim(ctx, nil, src, "other"); err == nil {
+		t.Errorf("Claimed webhooks should not be claimed by someone else")
+	}
+	if _, err := client.Subscribe(ctx, src, nil); err == nil {
+		t.Errorf("Subscriptions without the secret should be rejected")
+	}
+	if err := client.Alias(ctx, client.WithSecret(nil, "s3cret"), src, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	deliveries, err := client.Subscribe(subCtx, srv.URL+"/webhook/alice", &client.Options{
+		Header: http.Header{client.SecretHeader: []string{"s3cret"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(src, "application/json", strings.NewReader(`{"object":"page"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case <-deliveries:
+	case <-time.After(time.Second):
+		t.Fatalf("Delivery not received through the alias")
+	}
+
+	if err := client.Release(ctx, nil, src, "other"); err == nil {
+		t.Errorf("Only the owner should release the webhook")
+	}
+	if err := client.Release(ctx, nil, src, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := client.Claim(ctx, nil, src, "other"); !claimed || err != nil {
+		t.Errorf("Released webhooks should be claimable, got %v %v", claimed, err)
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	out, err := ioutil.TempFile(t.TempDir(), "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = out
+	r := relay.New(relay.WithRequestLog())
+	os.Stdout = stdout
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/webhook/abc123/status?secret=hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	b, _ := ioutil.ReadFile(out.Name())
+	if !strings.Contains(string(b), "/webhook/abc123/status?secret=REDACTED") || strings.Contains(string(b), "hunter2") {
+		t.Errorf("Query secrets should be redacted, got %q", b)
+	}
+}
+
+func TestSealedSubscription(t *testing.T) {
+	r := relay.New()
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	src := srv.URL + "/webhook/abc123"
+	deliveries, err := client.Subscribe(ctx, src, &client.Options{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(src, "application/json", strings.NewReader(`{"object":"page"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case d := <-deliveries:
+		if d.Body != `{"object":"page"}` {
+			t.Errorf("Sealed delivery should be opened, got %+v", d)
+		}
+		if _, err := client.FetchDelivery(ctx, http.DefaultClient, src, d.ID); err == nil {
+			t.Errorf("Sealed deliveries should not be retained")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Delivery not received")
+	}
+
+	if _, err := client.Subscribe(ctx, src+"?key=foo", nil); err == nil {
+		t.Errorf("Invalid keys should be rejected")
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	local, _ := relay.ParseCIDRs([]string{"127.0.0.1"})
+	r := relay.New(relay.WithIPRanges("local", local))
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	put := func(wid, body string) int {
+		req, _ := http.NewRequest("PUT", srv.URL+"/webhook/"+wid+"/allowlist", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put("abc123", `["nowhere"]`); status != http.StatusBadRequest {
+		t.Errorf("Unknown range sets should be rejected, got %d", status)
+	}
+	if status := put("abc123", `["192.0.2.0/24"]`); status != http.StatusOK {
+		t.Fatalf("Allowlist should be stored, got %d", status)
+	}
+	if status := put("def456", `["local"]`); status != http.StatusOK {
+		t.Fatalf("Allowlist should be stored, got %d", status)
+	}
+
+	for wid, status := range map[string]int{"abc123": http.StatusForbidden, "def456": http.StatusBadRequest} {
+		resp, err := http.Post(srv.URL+"/webhook/"+wid, "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d", wid, status, resp.StatusCode)
+		}
+	}
+	if s := r.Stats(); s.Allowed != 1 || s.Denied != 1 {
+		t.Errorf("Decisions should be counted, got %+v", s)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	pushed := make(chan *http.Request, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		pushed <- req
+	}))
+	defer target.Close()
+
+	r := relay.New(relay.WithPush(relay.PushOptions{AllowPrivate: true, MinBackoff: time.Millisecond}))
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	put := func(body string) int {
+		req, _ := http.NewRequest("PUT", srv.URL+"/webhook/staging/targets", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put(`[{"url":"ftp://example.com"}]`); status != http.StatusBadRequest {
+		t.Errorf("Invalid targets should be rejected, got %d", status)
+	}
+	many := strings.Repeat(`{"url":"https://example.com"},`, relay.MaxTargets)
+	if status := put(`[` + many + `{"url":"https://example.com"}]`); status != http.StatusBadRequest {
+		t.Errorf("More than MaxTargets targets should be rejected, got %d", status)
+	}
+	if status := put(`[{"url":"` + target.URL + `/hook"}]`); status != http.StatusOK {
+		t.Fatalf("Targets should be stored, got %d", status)
+	}
+
+	resp, err := http.Post(srv.URL+"/webhook/staging", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Deliveries with targets but no subscribers should be accepted, got %d", resp.StatusCode)
+	}
+	select {
+	case req := <-pushed:
+		if req.URL.Path != "/hook" || req.Header.Get("X-Fbwhs-Delivery") == "" {
+			t.Errorf("Unexpected push %s %v", req.URL.Path, req.Header)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Delivery not pushed")
+	}
+
+	var attempts []relay.PushAttempt
+	for deadline := time.Now().Add(time.Second); len(attempts) == 0 && time.Now().Before(deadline); {
+		resp, err := http.Get(srv.URL + "/webhook/staging/targets/log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&attempts)
+		resp.Body.Close()
+	}
+	if len(attempts) != 1 || attempts[0].StatusCode != http.StatusOK {
+		t.Errorf("Push should be logged, got %+v", attempts)
+	}
+}
+
+func TestView(t *testing.T) {
+	r := relay.New(relay.WithCORS("http://localhost:3000"))
+	defer r.Close()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/webhook/abc123/view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "EventSource") {
+		t.Errorf("View should be served, got %d", resp.StatusCode)
+	}
+
+	hc := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for origin, allowed := range map[string]string{
+		"http://localhost:3000": "http://localhost:3000",
+		"http://evil.example":   "",
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+"/webhook/abc123", nil)
+		req.Header.Set("Origin", origin)
+		resp, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != allowed {
+			t.Errorf("%s: expected %q, got %q", origin, allowed, got)
+		}
+	}
+}
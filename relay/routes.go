@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"fbwhs/internal"
+	"gopkg.in/macaron.v1"
+)
+
+func handleRoutesGet(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	c, _ := wh.Config(wh.Resolve(ctx.Params(":wid")))
+	routes := c.Routes
+	if routes == nil {
+		routes = []Route{}
+	}
+	ctx.JSON(http.StatusOK, routes)
+}
+
+func handleRoutesPut(ctx *macaron.Context, r *Relay, wh *internal.WebhookHandler, l *internal.Limiter) {
+	wid := wh.Resolve(ctx.Params(":wid"))
+	body, err := l.ReadBody(ctx.Req.Request)
+	if err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	var routes []Route
+	if err := json.Unmarshal([]byte(body), &routes); err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	for _, route := range routes {
+		if err := route.Validate(wid); err != nil {
+			ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
+		// Routing into a webhook is posting to it, which only its owner
+		// may allow.
+		if route.To != "" && !r.allowed(ctx.Req.Request, route.To, requestSecrets(ctx)...) {
+			ctx.PlainText(http.StatusForbidden, []byte("Routes can only target webhooks you own: "+route.To))
+			return
+		}
+	}
+
+	c, err := wh.UpdateConfig(wid, func(c *WebhookConfig) error {
+		c.Routes = routes
+		return nil
+	})
+	if err != nil {
+		ctx.PlainText(http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, c.Routes)
+}
+
+func handleRoutesDelete(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	_, err := wh.UpdateConfig(wh.Resolve(ctx.Params(":wid")), func(c *WebhookConfig) error {
+		c.Routes = nil
+		return nil
+	})
+	if err != nil {
+		ctx.PlainText(http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
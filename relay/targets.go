@@ -0,0 +1,73 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"fbwhs/internal"
+	"gopkg.in/macaron.v1"
+)
+
+func handleTargetsGet(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	c, _ := wh.Config(wh.Resolve(ctx.Params(":wid")))
+	targets := c.Targets
+	if targets == nil {
+		targets = []Target{}
+	}
+	ctx.JSON(http.StatusOK, targets)
+}
+
+func handleTargetsPut(ctx *macaron.Context, wh *internal.WebhookHandler, l *internal.Limiter) {
+	wid := wh.Resolve(ctx.Params(":wid"))
+	body, err := l.ReadBody(ctx.Req.Request)
+	if err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	var targets []Target
+	if err := json.Unmarshal([]byte(body), &targets); err != nil {
+		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	if len(targets) > internal.MaxTargets {
+		ctx.PlainText(http.StatusBadRequest, []byte(internal.ErrTooManyTargets.Error()))
+		return
+	}
+	for _, t := range targets {
+		if err := t.Validate(); err != nil {
+			ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
+	}
+
+	c, err := wh.UpdateConfig(wid, func(c *WebhookConfig) error {
+		c.Targets = targets
+		return nil
+	})
+	if err != nil {
+		ctx.PlainText(http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, c.Targets)
+}
+
+func handleTargetsDelete(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	_, err := wh.UpdateConfig(wh.Resolve(ctx.Params(":wid")), func(c *WebhookConfig) error {
+		c.Targets = nil
+		return nil
+	})
+	if err != nil {
+		ctx.PlainText(http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func handleTargetsLog(ctx *macaron.Context, wh *internal.WebhookHandler) {
+	attempts := wh.Pusher().Log(wh.Resolve(ctx.Params(":wid")))
+	if attempts == nil {
+		attempts = []PushAttempt{}
+	}
+	ctx.JSON(http.StatusOK, attempts)
+}
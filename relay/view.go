@@ -0,0 +1,36 @@
+package relay
+
+import (
+	_ "embed"
+	"net/http"
+
+	"gopkg.in/macaron.v1"
+)
+
+// viewPage subscribes to the webhook it is served under through EventSource
+// and renders its deliveries. Its query, e.g. the group, is passed on to the
+// subscription.
+//
+//go:embed view.html
+var viewPage []byte
+
+func handleView(ctx *macaron.Context) {
+	ctx.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ctx.Resp.WriteHeader(http.StatusOK)
+	ctx.Resp.Write(viewPage)
+}
+
+// allowOrigin lets browsers on the configured origins read the streams.
+func (r *Relay) allowOrigin(rw http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for _, o := range r.origins {
+		if o == "*" || o == origin {
+			rw.Header().Set("Access-Control-Allow-Origin", origin)
+			rw.Header().Add("Vary", "Origin")
+			return
+		}
+	}
+}
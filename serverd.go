@@ -1,79 +1,112 @@
 package main
 
 import (
-	"fmt"
 	"log"
-	"net/http"
+	"net"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
-	"fbwhs/internal"
+	"fbwhs/relay"
 	"gopkg.in/macaron.v1"
 )
 
-func handleFacebookVerification(ctx *macaron.Context) bool {
-	modes := ctx.QueryStrings("hub.mode")
-	if len(modes) != 1 || modes[0] != "subscribe" {
-		return false
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
 	}
-
-	challenges := ctx.QueryStrings("hub.challenge")
-	if len(challenges) != 1 {
-		ctx.Status(http.StatusBadRequest)
-		return true
-	}
-
-	wid := ctx.Params(":wid")
-	tokens := ctx.QueryStrings("hub.verify_token")
-	if len(tokens) != 1 || tokens[0] != wid {
-		ctx.Status(http.StatusBadRequest)
-		return true
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid %s: %s", name, err.Error())
 	}
-
-	ctx.PlainText(http.StatusOK, []byte(challenges[0]))
-	return true
+	return d
 }
 
-func handleWebhookConnect(ctx *macaron.Context, wh *internal.WebhookHandler) {
-	if handled := handleFacebookVerification(ctx); handled {
-		return
+func envInt64(name string, def int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
 	}
-
-	wid := ctx.Params(":wid")
-	eventID, err := wh.Subscribe(wid)
+	n, err := strconv.ParseInt(v, 10, 64)
 	if err != nil {
-		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
-		return
+		log.Fatalf("Invalid %s: %s", name, err.Error())
 	}
-
-	go wh.KeepAlive(eventID)
-	ctx.Redirect(fmt.Sprintf("/events?id=%s", eventID))
+	return n
 }
 
-func handleWebhookForward(ctx *macaron.Context, wh *internal.WebhookHandler) {
-	wid := ctx.Params(":wid")
-	body, _ := ctx.Req.Body().String()
-
-	if err := wh.Forward(wid, ctx.Req.Header, body); err != nil {
-		log.Printf("Forward error: %s", err.Error())
-		ctx.PlainText(http.StatusBadRequest, []byte(err.Error()))
-		return
+func envCIDRs(name string) []*net.IPNet {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	ranges, err := relay.ParseCIDRs(strings.Split(v, ","))
+	if err != nil {
+		log.Fatalf("Invalid %s: %s", name, err.Error())
 	}
-	ctx.Status(http.StatusOK)
+	return ranges
 }
 
 func main() {
 	host, port := macaron.GetDefaultListenInfo()
 	addr := host + ":" + strconv.Itoa(port)
 
-	m := macaron.Classic()
-	wh := internal.NewDefaultWebhookHandler()
-	mux := http.NewServeMux()
+	opts := []relay.Option{
+		relay.WithRequestLog(),
+		relay.WithExpiration(relay.Expiration{
+			Webhook:    envDuration("WEBHOOK_TTL", relay.DefaultExpiration.Webhook),
+			Deliveries: envDuration("DELIVERY_TTL", relay.DefaultExpiration.Deliveries),
+			Config:     envDuration("CONFIG_TTL", relay.DefaultExpiration.Config),
+		}, envDuration("SWEEP_INTERVAL", relay.DefaultSweepInterval)),
+		relay.WithLimits(relay.Limits{
+			MaxBodySize:       envInt64("MAX_BODY_SIZE", relay.DefaultLimits.MaxBodySize),
+			ReadHeaderTimeout: envDuration("READ_HEADER_TIMEOUT", relay.DefaultLimits.ReadHeaderTimeout),
+			ReadTimeout:       envDuration("READ_TIMEOUT", relay.DefaultLimits.ReadTimeout),
+			WriteTimeout:      envDuration("WRITE_TIMEOUT", relay.DefaultLimits.WriteTimeout),
+			IdleTimeout:       envDuration("IDLE_TIMEOUT", relay.DefaultLimits.IdleTimeout),
+		}),
+	}
+	// Claims, routes, allowlists and push targets are kept in the memory of
+	// each instance, so a cluster would let a webhook claimed on one be
+	// subscribed to through another.
+	if os.Getenv("CLUSTER_PEERS") != "" {
+		log.Fatal("CLUSTER_PEERS is not supported until config is shared between instances")
+	}
+
+	if sets := os.Getenv("IP_RANGES"); sets != "" {
+		for _, set := range strings.Split(sets, ",") {
+			name, path, ok := strings.Cut(set, "=")
+			if !ok {
+				log.Fatalf("Invalid IP_RANGES: %s, expected name=path", set)
+			}
+			ranges, err := relay.LoadCIDRs(path)
+			if err != nil {
+				log.Fatalf("Invalid IP_RANGES: %s", err.Error())
+			}
+			opts = append(opts, relay.WithIPRanges(name, ranges))
+		}
+	}
+	if proxies := envCIDRs("TRUSTED_PROXIES"); proxies != nil {
+		opts = append(opts, relay.WithTrustedProxies(proxies))
+	}
+
+	if os.Getenv("PUSH_RETRIES") != "" || os.Getenv("PUSH_TIMEOUT") != "" ||
+		os.Getenv("PUSH_MAX_PENDING") != "" || os.Getenv("PUSH_ALLOW_PRIVATE") != "" {
+		allowPrivate, _ := strconv.ParseBool(os.Getenv("PUSH_ALLOW_PRIVATE"))
+		opts = append(opts, relay.WithPush(relay.PushOptions{
+			Retries:      int(envInt64("PUSH_RETRIES", int64(relay.DefaultPushOptions.Retries))),
+			MinBackoff:   relay.DefaultPushOptions.MinBackoff,
+			MaxBackoff:   relay.DefaultPushOptions.MaxBackoff,
+			Timeout:      envDuration("PUSH_TIMEOUT", relay.DefaultPushOptions.Timeout),
+			MaxPending:   int(envInt64("PUSH_MAX_PENDING", int64(relay.DefaultPushOptions.MaxPending))),
+			AllowPrivate: allowPrivate,
+		}))
+	}
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		opts = append(opts, relay.WithCORS(strings.Split(origins, ",")...))
+	}
 
-	m.Map(wh)
-	m.Use(macaron.Renderer())
-	m.Get("/webhook/:wid", handleWebhookConnect)
-	m.Post("/webhook/:wid", handleWebhookForward)
-	mux.Handle("/", m)
-	mux.HandleFunc("/events", wh.HandleEvents)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	r := relay.New(opts...)
+	log.Fatal(r.Server(addr).ListenAndServe())
 }